@@ -0,0 +1,369 @@
+package dvmweb
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// adminPageSize is the number of stories shown per page in the admin area.
+const adminPageSize = 50
+
+// RequireCurator wraps a handler and only lets logged in curators through,
+// everyone else is redirected to the login page.
+func (h *Handler) RequireCurator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(SessionCookieName)
+		if err != nil {
+			http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.Path), http.StatusSeeOther)
+			return
+		}
+		curator, err := h.App.Store.SessionCurator(cookie.Value)
+		if err == ErrSessionNotFound {
+			http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.Path), http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			writeHeaderLogf(w, http.StatusInternalServerError, "session lookup failed: %v", err)
+			return
+		}
+		next(w, r.WithContext(withCurator(r.Context(), curator)))
+	}
+}
+
+// LoginHandler renders the login form and starts a session for curators.
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/admin") {
+		next = "/admin"
+	}
+	var failed bool
+	if r.Method == "POST" {
+		name := r.FormValue("name")
+		c, err := h.App.Store.CuratorByName(name)
+		switch {
+		case err == ErrCuratorNotFound:
+			failed = true
+		case err != nil:
+			writeHeaderLogf(w, http.StatusInternalServerError, "curator lookup failed: %v", err)
+			return
+		case !checkPassword(c.Password, r.FormValue("password")):
+			failed = true
+		}
+		if failed {
+			log.Printf("failed login for %q from %s", name, h.clientIP(r))
+		} else {
+			token, err := newSessionToken()
+			if err != nil {
+				writeHeaderLog(w, http.StatusInternalServerError, err)
+				return
+			}
+			expires := time.Now().Add(SessionLifetime)
+			if err := h.App.Store.CreateSession(token, c.Name, expires); err != nil {
+				writeHeaderLogf(w, http.StatusInternalServerError, "cannot create session: %v", err)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     SessionCookieName,
+				Value:    token,
+				Path:     "/admin",
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			log.Printf("curator %s logged in", c.Name)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+	}
+	t, err := template.New("login.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "login.html"))
+	if t == nil || err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "failed or missing template: %v", err)
+		return
+	}
+	var data = struct {
+		Next   string
+		Failed bool
+	}{
+		Next:   next,
+		Failed: failed,
+	}
+	if failed {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("render failed: %v", err)
+	}
+}
+
+// LogoutHandler ends a curator session.
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeHeaderLog(w, http.StatusMethodNotAllowed, "logout requires POST")
+		return
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		if err := h.App.Store.DeleteSession(cookie.Value); err != nil {
+			log.Printf("cannot delete session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Path:     "/admin",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// CategoryHealth reports the number of images in a category.
+type CategoryHealth struct {
+	Name   string
+	Images int
+}
+
+// AdminHandler renders the admin overview: stories, inventory and cache.
+func (h *Handler) AdminHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.New("admin.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "admin.html"))
+	if t == nil || err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "failed or missing template: %v", err)
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	stories, err := h.App.Store.AllStories(adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	var categories []CategoryHealth
+	inv := h.App.Inventory()
+	names := inv.Categories()
+	sort.Strings(names)
+	for _, c := range names {
+		categories = append(categories, CategoryHealth{
+			Name:   c,
+			Images: len(inv.ByCategory(c)),
+		})
+	}
+	var data = struct {
+		Curator    string
+		Stories    []Story
+		PrevPage   int
+		NextPage   int
+		Categories []CategoryHealth
+		Videos     int
+		Ok         bool
+		Cache      CacheStats
+		Version    string
+	}{
+		Curator:    CuratorFromContext(r.Context()),
+		Stories:    stories,
+		PrevPage:   page - 1,
+		Categories: categories,
+		Videos:     len(inv.Videos),
+		Ok:         inv.Ok(),
+		Cache:      h.Compositor.Stats(),
+		Version:    h.Version,
+	}
+	if len(stories) == adminPageSize {
+		data.NextPage = page + 1
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("render failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// EditStoryHandler renders a form to edit a story and saves changes.
+func (h *Handler) EditStoryHandler(w http.ResponseWriter, r *http.Request) {
+	identifier, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeHeaderLog(w, http.StatusBadRequest, err)
+		return
+	}
+	story, err := h.App.Store.StoryByID(identifier)
+	if err == ErrStoryNotFound {
+		writeHeaderLogf(w, http.StatusNotFound, "no such story: %d", identifier)
+		return
+	}
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	if r.Method == "POST" {
+		curator := CuratorFromContext(r.Context())
+		story.Text = strings.TrimSpace(r.FormValue("story"))
+		story.Language = r.FormValue("language")
+		if story.Status, err = strconv.Atoi(r.FormValue("status")); err != nil ||
+			story.Status < StatusVisible || story.Status > StatusApproved {
+			writeHeaderLogf(w, http.StatusBadRequest, "invalid status: %v", r.FormValue("status"))
+			return
+		}
+		if len(story.Text) == 0 {
+			writeHeaderLog(w, http.StatusBadRequest, "empty story, delete instead")
+			return
+		}
+		if err := h.App.Store.UpdateStory(*story, curator); err != nil {
+			writeHeaderLogf(w, http.StatusInternalServerError, "update failed: %v", err)
+			return
+		}
+		log.Printf("curator %s: edit story %d", curator, identifier)
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	t, err := template.New("edit.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "edit.html"))
+	if t == nil || err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "failed or missing template: %v", err)
+		return
+	}
+	var data = struct {
+		Story Story
+	}{
+		Story: *story,
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("render failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteStoryHandler removes a story for good.
+func (h *Handler) DeleteStoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeHeaderLog(w, http.StatusMethodNotAllowed, "delete requires POST")
+		return
+	}
+	identifier, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeHeaderLog(w, http.StatusBadRequest, err)
+		return
+	}
+	curator := CuratorFromContext(r.Context())
+	err = h.App.Store.Moderate(identifier, ActionDelete, curator)
+	if err == ErrStoryNotFound {
+		writeHeaderLogf(w, http.StatusNotFound, "no such story: %d", identifier)
+		return
+	}
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "delete failed: %v", err)
+		return
+	}
+	log.Printf("curator %s: delete story %d", curator, identifier)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// ClearCacheHandler removes all cached composite images.
+func (h *Handler) ClearCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeHeaderLog(w, http.StatusMethodNotAllowed, "clearing cache requires POST")
+		return
+	}
+	removed, err := h.Compositor.RemoveIf(func(string) bool { return true })
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "cannot remove cached file: %v", err)
+		return
+	}
+	log.Printf("curator %s: cleared %d cached files", CuratorFromContext(r.Context()), removed)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// ModerationHandler lists flagged stories and applies curator decisions.
+func (h *Handler) ModerationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		curator := CuratorFromContext(r.Context())
+		identifier, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			writeHeaderLog(w, http.StatusBadRequest, err)
+			return
+		}
+		action := r.FormValue("action")
+		if err := checkAction(action); err != nil {
+			writeHeaderLog(w, http.StatusBadRequest, err)
+			return
+		}
+		err = h.App.Store.Moderate(identifier, action, curator)
+		if err == ErrStoryNotFound {
+			writeHeaderLogf(w, http.StatusNotFound, "no such story: %d", identifier)
+			return
+		}
+		if err != nil {
+			writeHeaderLogf(w, http.StatusInternalServerError, "moderation failed: %v", err)
+			return
+		}
+		log.Printf("curator %s: %s story %d", curator, action, identifier)
+		if _, ok := labelByAction[action]; ok {
+			h.trainSpamCheckers()
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	t, err := template.New("moderation.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "moderation.html"))
+	if t == nil || err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "failed or missing template: %v", err)
+		return
+	}
+	queue, err := h.App.Store.ModerationQueue()
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	entries, err := h.App.Store.ModerationLog(50)
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	var data = struct {
+		Queue []QueueItem
+		Log   []ModerationEntry
+	}{
+		Queue: queue,
+		Log:   entries,
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("render failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// trainSpamCheckers retrains all checkers, which learn from curator labels.
+func (h *Handler) trainSpamCheckers() {
+	for _, c := range h.SpamCheckers {
+		if t, ok := c.(Trainer); ok {
+			if err := t.Train(); err != nil {
+				log.Printf("training spam checker failed: %v", err)
+			}
+		}
+	}
+}
+
+// statusName returns a human readable moderation status.
+func statusName(status int) string {
+	switch status {
+	case StatusVisible:
+		return "sichtbar"
+	case StatusFlagged:
+		return "gemeldet"
+	case StatusHidden:
+		return "verborgen"
+	case StatusApproved:
+		return "freigegeben"
+	default:
+		return fmt.Sprintf("unbekannt (%d)", status)
+	}
+}
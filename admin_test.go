@@ -0,0 +1,60 @@
+package dvmweb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModeration(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+	s := h.App.Store
+	if err := s.AddCurator("alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSession("token", "alice", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InsertStory(Story{ImageIdentifier: "010203", Text: "Flachs.", Status: StatusFlagged}); err != nil {
+		t.Fatal(err)
+	}
+	// moderate posts a curator decision with the session.
+	moderate := func(id, action string) *httptest.ResponseRecorder {
+		form := url.Values{"id": {id}, "action": {action}}
+		req := httptest.NewRequest("POST", "/admin/moderation", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "token"})
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	var cases = []struct {
+		id, action string
+		want       int
+	}{
+		{"1", ActionApprove, http.StatusSeeOther},
+		{"99", ActionApprove, http.StatusNotFound},
+		{"x", ActionApprove, http.StatusBadRequest},
+		{"1", "bogus", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if got := moderate(c.id, c.action).Code; got != c.want {
+			t.Errorf("%s %s: got %d, want %d", c.action, c.id, got, c.want)
+		}
+	}
+	// A new password ends the session.
+	if err := s.AddCurator("alice", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := moderate("1", ActionHide); !strings.HasPrefix(rec.Header().Get("Location"), "/admin/login") {
+		t.Fatalf("after password change: got %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if story, err := s.StoryByID(1); err != nil || story.Status != StatusApproved {
+		t.Errorf("after password change: got %+v, %v, want still approved", story, err)
+	}
+}
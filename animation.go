@@ -0,0 +1,254 @@
+package dvmweb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// AnimationOptions configure the slot machine animation. Each slot is a reel,
+// a vertical strip of images of the slot category, which ends with the image
+// of the requested combination. All reels start at once, every reel stops
+// Offset frames after the one to its left.
+type AnimationOptions struct {
+	Width  int    // Width in pixels, half the native width of the slots, if zero.
+	Frames int    // Frames until the first reel stops.
+	Offset int    // Frames between stopping reels.
+	Delay  int    // Delay between frames in 1/100s.
+	Tiles  int    // Images passing by on the first reel.
+	Easing string // Name of the easing curve, see EasingNames.
+}
+
+// DefaultAnimationOptions are used for the animation endpoint.
+var DefaultAnimationOptions = AnimationOptions{
+	Frames: 30,
+	Offset: 8,
+	Delay:  5,
+	Tiles:  12,
+	Easing: "cubic-out",
+}
+
+// Validate checks the options.
+func (o AnimationOptions) Validate() error {
+	if o.Width < 0 || o.Frames < 1 || o.Offset < 0 || o.Delay < 0 || o.Tiles < 1 {
+		return fmt.Errorf("invalid animation options: %+v", o)
+	}
+	_, err := ParseEasing(o.Easing)
+	return err
+}
+
+// reelImages returns the images of a reel: n images of the slot, the target
+// image last. The choice depends on the combination only, so an animation is
+// rendered the same way every time.
+func reelImages(inv *Inventory, slot Slot, target CategorizedImage, iid string, n int) []CategorizedImage {
+	candidates := inv.slotImages(slot)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Identifier < candidates[j].Identifier
+	})
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s", iid, slot.Category)
+	rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+	images := make([]CategorizedImage, n)
+	for i := 0; i < n-1; i++ {
+		images[i] = candidates[rnd.Intn(len(candidates))]
+	}
+	images[n-1] = target
+	return images
+}
+
+// animationReels returns the images of every reel of an animation.
+func animationReels(inv *Inventory, cid CompositeID, opts AnimationOptions) [][]CategorizedImage {
+	var (
+		iid   = cid.String()
+		reels = make([][]CategorizedImage, len(inv.Slots))
+	)
+	for i, slot := range inv.Slots {
+		// Later reels run longer at the same speed, so they need more images.
+		n := opts.Tiles * (opts.Frames + i*opts.Offset) / opts.Frames
+		reels[i] = reelImages(inv, slot, cid.Images[i], iid, n)
+	}
+	return reels
+}
+
+// animationName is the cache file name of an animation, it contains all
+// options, the easing last, since its name contains dashes.
+func animationName(cid CompositeID, opts AnimationOptions) string {
+	return fmt.Sprintf("%s-w%d-f%d-o%d-d%d-t%d-%s.gif",
+		cid, opts.Width, opts.Frames, opts.Offset, opts.Delay, opts.Tiles, opts.Easing)
+}
+
+// parseAnimationName returns combination and options of an animation cache
+// file name, ok is false for other files.
+func parseAnimationName(name string) (iid string, opts AnimationOptions, ok bool) {
+	if !strings.HasSuffix(name, ".gif") {
+		return "", opts, false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(name, ".gif"), "-", 7)
+	if len(parts) != 7 {
+		return "", opts, false
+	}
+	for i, v := range []struct {
+		format string
+		dst    *int
+	}{
+		{"w%d", &opts.Width},
+		{"f%d", &opts.Frames},
+		{"o%d", &opts.Offset},
+		{"d%d", &opts.Delay},
+		{"t%d", &opts.Tiles},
+	} {
+		if _, err := fmt.Sscanf(parts[i+1], v.format, v.dst); err != nil {
+			return "", opts, false
+		}
+	}
+	opts.Easing = parts[6]
+	return parts[0], opts, opts.Validate() == nil
+}
+
+// reelStrip stacks the tiles of a reel vertically.
+func reelStrip(images []CategorizedImage, tileWidth, tileHeight int, tiles map[string]image.Image) (*image.NRGBA, error) {
+	strip := imaging.New(tileWidth, tileHeight*len(images), color.NRGBA{0, 0, 0, 255})
+	for i, cimg := range images {
+		tile, ok := tiles[cimg.Path]
+		if !ok {
+			img, err := imaging.Open(cimg.Path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open image at %s: %v", cimg.Path, err)
+			}
+			// Like in composites, images are cut off on the right.
+			img = imaging.Resize(img, 0, tileHeight, imaging.Lanczos)
+			tile = imaging.Crop(img, image.Rect(0, 0, tileWidth, tileHeight))
+			tiles[cimg.Path] = tile
+		}
+		strip = imaging.Paste(strip, tile, image.Pt(0, tileHeight*i))
+	}
+	return strip, nil
+}
+
+// RenderAnimation writes an animated GIF of a slot machine, which lands on the
+// given combination. Frames are rendered at a constant rate, the easing curve
+// determines how far the reels move per frame.
+func RenderAnimation(w io.Writer, inv *Inventory, cid CompositeID, opts AnimationOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	ease, _ := ParseEasing(opts.Easing)
+	slots := inv.Slots
+	native, nativeHeight := slots.CanvasSize()
+	if opts.Width == 0 {
+		opts.Width = native / 2
+	}
+	scale := float64(opts.Width) / float64(native)
+	var (
+		tileWidth  = int(math.Round(TileWidth * scale))
+		tileHeight = int(math.Round(float64(nativeHeight) * scale))
+		tiles      = make(map[string]image.Image)
+		strips     = make([]*image.NRGBA, len(slots))
+		durations  = make([]int, len(slots))
+	)
+	for i, reel := range animationReels(inv, cid, opts) {
+		durations[i] = opts.Frames + i*opts.Offset
+		strip, err := reelStrip(reel, tileWidth, tileHeight, tiles)
+		if err != nil {
+			return err
+		}
+		strips[i] = strip
+	}
+	var samples []image.Image
+	for _, tile := range tiles {
+		samples = append(samples, tile)
+	}
+	q := newQuantizer(samples)
+	var (
+		bounds = image.Rect(0, 0, tileWidth*len(slots), tileHeight)
+		total  = durations[len(durations)-1]
+		anim   = &gif.GIF{LoopCount: -1} // Play once and keep the last frame.
+	)
+	for f := 0; f <= total; f++ {
+		frame := image.NewPaletted(bounds, q.palette)
+		for i, strip := range strips {
+			t := math.Min(float64(f)/float64(durations[i]), 1)
+			distance := strip.Bounds().Dy() - tileHeight
+			y := int(math.Round(ease(t) * float64(distance)))
+			q.draw(frame, image.Rect(tileWidth*i, 0, tileWidth*(i+1), tileHeight), strip, image.Pt(0, y))
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, opts.Delay)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// quantizer maps colors to a palette of the most frequent colors of some
+// images, via a lookup table of 15 bit colors.
+type quantizer struct {
+	palette color.Palette
+	lookup  []uint8
+}
+
+// newQuantizer builds a palette of at most 256 colors, the averages of the
+// most frequent 15 bit colors in the samples.
+func newQuantizer(samples []image.Image) *quantizer {
+	type bucket struct {
+		n       int
+		r, g, b int
+	}
+	buckets := make([]bucket, 1<<15)
+	for _, img := range samples {
+		src := imaging.Clone(img)
+		for i := 0; i < len(src.Pix); i += 4 {
+			r, g, b := int(src.Pix[i]), int(src.Pix[i+1]), int(src.Pix[i+2])
+			k := r>>3<<10 | g>>3<<5 | b>>3
+			buckets[k].n++
+			buckets[k].r += r
+			buckets[k].g += g
+			buckets[k].b += b
+		}
+	}
+	var keys []int
+	for k, b := range buckets {
+		if b.n > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return buckets[keys[i]].n > buckets[keys[j]].n
+	})
+	if len(keys) > 256 {
+		keys = keys[:256]
+	}
+	q := &quantizer{lookup: make([]uint8, 1<<15)}
+	for _, k := range keys {
+		b := buckets[k]
+		q.palette = append(q.palette, color.RGBA{uint8(b.r / b.n), uint8(b.g / b.n), uint8(b.b / b.n), 255})
+	}
+	if len(q.palette) == 0 {
+		q.palette = color.Palette{color.Black}
+	}
+	for k := range q.lookup {
+		// The center of the 15 bit color.
+		c := color.RGBA{uint8(k>>10<<3 | 4), uint8(k>>5&31<<3 | 4), uint8(k&31<<3 | 4), 255}
+		q.lookup[k] = uint8(q.palette.Index(c))
+	}
+	return q
+}
+
+// draw converts the source starting at sp into the rectangle r of dst.
+func (q *quantizer) draw(dst *image.Paletted, r image.Rectangle, src *image.NRGBA, sp image.Point) {
+	for y := 0; y < r.Dy(); y++ {
+		so := src.PixOffset(sp.X, sp.Y+y)
+		do := dst.PixOffset(r.Min.X, r.Min.Y+y)
+		for x := 0; x < r.Dx(); x++ {
+			p := src.Pix[so+4*x : so+4*x+3]
+			dst.Pix[do+x] = q.lookup[int(p[0])>>3<<10|int(p[1])>>3<<5|int(p[2])>>3]
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package dvmweb
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+)
+
+func TestRenderAnimation(t *testing.T) {
+	inv, err := createInventory("static/images", "static/videos", DefaultSlots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid, err := inv.ParseCompositeID("010203")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A tenth of the native size, 32x30 pixels per slot.
+	opts := AnimationOptions{Width: 96, Frames: 4, Offset: 2, Delay: 7, Tiles: 3, Easing: "linear"}
+	var buf bytes.Buffer
+	if err := RenderAnimation(&buf, inv, cid, opts); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last reel stops after 4+2*2 frames, plus the first frame.
+	if len(anim.Image) != 9 {
+		t.Errorf("got %d frames, want 9", len(anim.Image))
+	}
+	if w, h := anim.Config.Width, anim.Config.Height; w != 96 || h != 30 {
+		t.Errorf("got %dx%d, want 96x30", w, h)
+	}
+	for i, d := range anim.Delay {
+		if d != 7 {
+			t.Errorf("frame %d: got delay %d, want 7", i, d)
+		}
+	}
+	if err := RenderAnimation(&buf, inv, cid, AnimationOptions{Frames: 0, Tiles: 1, Easing: "linear"}); err == nil {
+		t.Error("invalid options accepted")
+	}
+}
+
+func TestAnimationName(t *testing.T) {
+	cid := CompositeID{Images: []CategorizedImage{{Identifier: "01"}, {Identifier: "02"}, {Identifier: "03"}}}
+	opts := AnimationOptions{Width: 480, Frames: 30, Offset: 8, Delay: 5, Tiles: 12, Easing: "cubic-in-out"}
+	name := animationName(cid, opts)
+	iid, got, ok := parseAnimationName(name)
+	if !ok || iid != "010203" || got != opts {
+		t.Errorf("%s: got %s, %+v, %v", name, iid, got, ok)
+	}
+	// Every option is part of the name.
+	seen := map[string]bool{name: true}
+	for _, o := range []AnimationOptions{
+		{Width: 240, Frames: 30, Offset: 8, Delay: 5, Tiles: 12, Easing: "cubic-in-out"},
+		{Width: 480, Frames: 20, Offset: 8, Delay: 5, Tiles: 12, Easing: "cubic-in-out"},
+		{Width: 480, Frames: 30, Offset: 4, Delay: 5, Tiles: 12, Easing: "cubic-in-out"},
+		{Width: 480, Frames: 30, Offset: 8, Delay: 3, Tiles: 12, Easing: "cubic-in-out"},
+		{Width: 480, Frames: 30, Offset: 8, Delay: 5, Tiles: 6, Easing: "cubic-in-out"},
+		{Width: 480, Frames: 30, Offset: 8, Delay: 5, Tiles: 12, Easing: "linear"},
+	} {
+		if n := animationName(cid, o); seen[n] {
+			t.Errorf("%+v: name %s already used", o, n)
+		} else {
+			seen[n] = true
+		}
+	}
+	for _, name := range []string{"010203.gif", "010203-w480.jpg", "010203-w480-f30-o8-d5-t12-bounce.gif"} {
+		if _, _, ok := parseAnimationName(name); ok {
+			t.Errorf("%s: parsed as animation", name)
+		}
+	}
+}
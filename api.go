@@ -0,0 +1,269 @@
+package dvmweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Pagination defaults and limits for the API.
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// Page is a paginated list of items. Next is empty on the last page.
+type Page struct {
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Items   interface{} `json:"items"`
+	Next    string      `json:"next,omitempty"`
+}
+
+// Combination is a composite image made up of one image per category.
+type Combination struct {
+	Identifier string             `json:"id"`
+	ImageURL   string             `json:"image_url"`
+	Images     []CategorizedImage `json:"images"`
+	Video      *Video             `json:"video,omitempty"` // If there is a video of this combination.
+}
+
+// Category lists the images of a category.
+type Category struct {
+	Name   string             `json:"name"`
+	Images []CategorizedImage `json:"images"`
+}
+
+// apiError is the body of all API error responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON encodes v as JSON with a given status code.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("encode failed: %v", err)
+	}
+}
+
+// writeJSONError logs an error and writes a JSON error message.
+func writeJSONError(w http.ResponseWriter, statusCode int, err error) {
+	log.Println(err)
+	writeJSON(w, statusCode, apiError{Error: err.Error()})
+}
+
+// APINotFoundHandler answers requests for unknown API paths.
+func (h *Handler) APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, fmt.Errorf("not found: %s", r.URL.Path))
+}
+
+// APIMethodNotAllowedHandler answers requests with a method, which an API
+// path does not support.
+func (h *Handler) APIMethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s %s", r.Method, r.URL.Path))
+}
+
+// pagination returns page and items per page from the query string.
+func pagination(r *http.Request) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	q := r.URL.Query()
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page: %s", v)
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	return page, perPage, nil
+}
+
+// newPage wraps stories in a page and links to the next page, if the current
+// one is full.
+func newPage(r *http.Request, page, perPage int, stories []Story) Page {
+	if stories == nil {
+		stories = []Story{}
+	}
+	p := Page{Page: page, PerPage: perPage, Items: stories}
+	if len(stories) == perPage {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(page+1))
+		q.Set("per_page", strconv.Itoa(perPage))
+		p.Next = (&url.URL{Path: r.URL.Path, RawQuery: q.Encode()}).String()
+	}
+	return p
+}
+
+// APIStoriesHandler lists recent stories, newest first.
+func (h *Handler) APIStoriesHandler(w http.ResponseWriter, r *http.Request) {
+	page, perPage, err := pagination(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	stories, err := h.App.Store.RecentStories(perPage, (page-1)*perPage)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(r, page, perPage, stories))
+}
+
+// APIImageStoriesHandler lists the stories for an image identifier.
+func (h *Handler) APIImageStoriesHandler(w http.ResponseWriter, r *http.Request) {
+	page, perPage, err := pagination(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	cid, err := h.App.Inventory().ParseCompositeID(mux.Vars(r)["iid"])
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	stories, err := h.App.Store.StoriesByImage(cid.String())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	offset := (page - 1) * perPage
+	if offset >= len(stories) {
+		stories = nil
+	} else {
+		stories = stories[offset:]
+	}
+	if len(stories) > perPage {
+		stories = stories[:perPage]
+	}
+	writeJSON(w, http.StatusOK, newPage(r, page, perPage, stories))
+}
+
+// APIStoryHandler returns a single story.
+func (h *Handler) APIStoryHandler(w http.ResponseWriter, r *http.Request) {
+	identifier, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	story, err := h.App.Store.StoryByID(identifier)
+	if err == ErrStoryNotFound || (err == nil && !story.Public()) {
+		writeJSONError(w, http.StatusNotFound, ErrStoryNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, story)
+}
+
+// APICreateStoryHandler saves a new story, passed as JSON object with image,
+// text and language fields. Validation is the same as for the web form.
+func (h *Handler) APICreateStoryHandler(w http.ResponseWriter, r *http.Request) {
+	var story Story
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxStoryLength)).Decode(&story); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %v", err))
+		return
+	}
+	if story.ImageIdentifier == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("image is required"))
+		return
+	}
+	if story.Language == "" {
+		story.Language = "ger"
+	}
+	id, err := h.saveStory(r, h.App.Inventory(), story)
+	switch err {
+	case nil:
+	case errEmptyStory, errStoryTooLong, errSpam, errInvalidImage:
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	saved, err := h.App.Store.StoryByID(int(id))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/stories/%d", id))
+	writeJSON(w, http.StatusCreated, saved)
+}
+
+// APIRandomHandler returns a random combination of images, drawn with the
+// configured or requested strategy.
+func (h *Handler) APIRandomHandler(w http.ResponseWriter, r *http.Request) {
+	strategy, err := h.strategy(r, "api")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	inv := h.App.Inventory()
+	iid, err := h.randomImageIdentifier(inv, strategy)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c := Combination{
+		Identifier: iid,
+		ImageURL:   fmt.Sprintf("/c/%s.jpg", iid),
+	}
+	cid, err := inv.ParseCompositeID(iid)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.Images = cid.Images
+	c.Video = inv.VideoForCombination(iid)
+	writeJSON(w, http.StatusOK, c)
+}
+
+// APIVideosHandler lists all videos along with the combination they show.
+func (h *Handler) APIVideosHandler(w http.ResponseWriter, r *http.Request) {
+	videos := h.App.Inventory().Videos
+	if videos == nil {
+		videos = []Video{}
+	}
+	writeJSON(w, http.StatusOK, videos)
+}
+
+// APICategoriesHandler lists all image categories with their images.
+func (h *Handler) APICategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	inv := h.App.Inventory()
+	names := inv.Categories()
+	sort.Strings(names)
+	categories := []Category{}
+	for _, name := range names {
+		images := inv.ByCategory(name)
+		sort.Slice(images, func(i, j int) bool {
+			return images[i].Identifier < images[j].Identifier
+		})
+		categories = append(categories, Category{Name: name, Images: images})
+	}
+	writeJSON(w, http.StatusOK, categories)
+}
+
+// APISearchHandler returns search results.
+func (h *Handler) APISearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery(r)
+	results, err := h.search(q)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,44 @@
+package dvmweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrors(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+
+	var cases = []struct {
+		method, target string
+		want           int
+	}{
+		{"GET", "/api/v1/stories/abc", http.StatusNotFound},
+		{"GET", "/api/v1/stories/1", http.StatusNotFound},
+		{"GET", "/api/v1/nothing", http.StatusNotFound},
+		{"GET", "/api/v1/", http.StatusNotFound},
+		{"GET", "/api/v1/images/999999/stories", http.StatusNotFound},
+		{"DELETE", "/api/v1/stories", http.StatusMethodNotAllowed},
+		{"POST", "/api/v1/random", http.StatusMethodNotAllowed},
+		{"GET", "/api/v1/stories?page=x", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rec := serve(r, c.method, c.target, nil)
+		if rec.Code != c.want {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.target, rec.Code, c.want)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: got content type %q", c.method, c.target, ct)
+		}
+		var body apiError
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+			t.Errorf("%s %s: got body %q, %v", c.method, c.target, rec.Body.String(), err)
+		}
+	}
+	// Outside of the API, the HTML page is rendered.
+	if rec := serve(r, "GET", "/nothing", nil); rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") == "application/json" {
+		t.Errorf("GET /nothing: got %d, %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,68 @@
+package dvmweb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrCuratorNotFound is returned for unknown curator names.
+	ErrCuratorNotFound = errors.New("curator not found")
+	// ErrSessionNotFound is returned for unknown or expired sessions.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionCookieName is the name of the cookie carrying the session token.
+const SessionCookieName = "dvmsession"
+
+// SessionLifetime is the time after which curators need to login again.
+const SessionLifetime = 12 * time.Hour
+
+// Curator can access the admin area.
+type Curator struct {
+	Name     string    `db:"name"`
+	Password string    `db:"password"` // bcrypt hash
+	Created  time.Time `db:"created"`
+}
+
+// HashPassword returns a bcrypt hash of a password.
+func HashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", errors.New("password must be at least 8 characters long")
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+// checkPassword compares a password with a bcrypt hash.
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// newSessionToken returns a random, hex encoded session token.
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// curatorKey is the context key for the logged in curator.
+type curatorKey struct{}
+
+// withCurator attaches a curator name to a context.
+func withCurator(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, curatorKey{}, name)
+}
+
+// CuratorFromContext returns the name of the logged in curator, if any.
+func CuratorFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(curatorKey{}).(string)
+	return name
+}
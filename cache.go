@@ -0,0 +1,304 @@
+package dvmweb
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CacheLimits bounds the composite cache, zero values mean no limit. The
+// memory tier is disabled, if MemoryBytes is zero.
+type CacheLimits struct {
+	MaxFiles    int   // Files on disk.
+	MaxBytes    int64 // Bytes on disk.
+	MemoryBytes int64 // Bytes of composites kept in memory.
+}
+
+// CacheStats summarizes the composite image cache.
+type CacheStats struct {
+	Files         int
+	Bytes         int64
+	MemoryEntries int
+	MemoryBytes   int64
+	MemoryHits    int64 // Served from memory.
+	DiskHits      int64 // Read from disk.
+	Misses        int64 // Rendered.
+	Shared        int64 // Waited for a rendering already in progress.
+	Evictions     int64 // Files removed to stay within limits.
+}
+
+// Composite is a rendered composite image.
+type Composite struct {
+	Data []byte
+	ETag string // Derived from the data.
+}
+
+// cacheEntry is an element of a lru list, data is only kept in memory.
+type cacheEntry struct {
+	key  string
+	size int64
+	data *Composite
+}
+
+// lru tracks cache entries in order of use, most recent first.
+type lru struct {
+	ll    *list.List
+	items map[string]*list.Element
+	size  int64
+}
+
+func newLRU() *lru {
+	return &lru{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns an entry and marks it as used.
+func (l *lru) get(key string) (*cacheEntry, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry), true
+}
+
+// add adds or replaces an entry as the most recently used.
+func (l *lru) add(entry *cacheEntry) {
+	l.remove(entry.key)
+	l.items[entry.key] = l.ll.PushFront(entry)
+	l.size += entry.size
+}
+
+// remove drops an entry, if it exists.
+func (l *lru) remove(key string) {
+	if e, ok := l.items[key]; ok {
+		l.size -= e.Value.(*cacheEntry).size
+		l.ll.Remove(e)
+		delete(l.items, key)
+	}
+}
+
+// oldest returns the least recently used entry or nil.
+func (l *lru) oldest() *cacheEntry {
+	if e := l.ll.Back(); e != nil {
+		return e.Value.(*cacheEntry)
+	}
+	return nil
+}
+
+func (l *lru) len() int {
+	return l.ll.Len()
+}
+
+// over returns true, if there are more than n entries or bytes; zero limits
+// are ignored.
+func (l *lru) over(n int, size int64) bool {
+	return (n > 0 && l.len() > n) || (size > 0 && l.size > size)
+}
+
+// scan takes existing files into the cache, oldest first, and evicts files
+// over the limits.
+func (c *Compositor) scan() error {
+	fis, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().Before(fis[j].ModTime())
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		c.disk.add(&cacheEntry{key: fi.Name(), size: fi.Size()})
+	}
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries, until the cache is within
+// its limits. The most recent entry is kept in any case. Callers hold c.mu.
+func (c *Compositor) evict() {
+	for c.disk.len() > 1 && c.disk.over(c.Limits.MaxFiles, c.Limits.MaxBytes) {
+		entry := c.disk.oldest()
+		c.disk.remove(entry.key)
+		c.memory.remove(entry.key)
+		if err := os.Remove(filepath.Join(c.Dir, entry.key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("cache eviction failed: %v", err)
+		}
+		c.stats.Evictions++
+	}
+	for c.memory.len() > 0 && c.memory.over(0, c.Limits.MemoryBytes) {
+		c.memory.remove(c.memory.oldest().key)
+	}
+}
+
+// remember keeps a composite in memory, if the memory tier is enabled and the
+// composite is not larger than the tier. Callers hold c.mu.
+func (c *Compositor) remember(name string, composite *Composite) {
+	size := int64(len(composite.Data))
+	if c.Limits.MemoryBytes == 0 || size > c.Limits.MemoryBytes {
+		return
+	}
+	c.memory.add(&cacheEntry{key: name, size: size, data: composite})
+	c.evict()
+}
+
+// Get returns a composite variant of a combination from memory, disk or by
+// rendering it.
+func (c *Compositor) Get(slots Slots, cid CompositeID, v Variant) (*Composite, error) {
+	return c.get(compositeName(slots, cid, v), c.renderer(slots, cid, v))
+}
+
+// Animation returns the slot machine animation of a combination from memory,
+// disk or by rendering it.
+func (c *Compositor) Animation(inv *Inventory, cid CompositeID, opts AnimationOptions) (*Composite, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return c.get(animationName(cid, opts), func(w io.Writer) error {
+		return RenderAnimation(w, inv, cid, opts)
+	})
+}
+
+// get returns a cached file from memory or disk, it is created with render, if
+// it does not exist.
+func (c *Compositor) get(name string, render func(io.Writer) error) (*Composite, error) {
+	c.mu.Lock()
+	if entry, ok := c.memory.get(name); ok {
+		c.stats.MemoryHits++
+		c.disk.get(name)
+		c.mu.Unlock()
+		return entry.data, nil
+	}
+	c.mu.Unlock()
+	filename, fresh, err := c.file(name, render)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(filename)
+	evicted := os.IsNotExist(err)
+	if evicted {
+		// Evicted by a concurrent call before it could be read, which only
+		// happens under pressure, so render again without the disk.
+		b, err = c.renderBytes(render)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read composite: %v", err)
+	}
+	composite := &Composite{Data: b, ETag: fmt.Sprintf(`"%x"`, sha1.Sum(b))}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case evicted:
+		c.stats.Misses++
+	case !fresh:
+		c.stats.DiskHits++
+		if _, ok := c.disk.get(name); !ok {
+			// Created outside of the compositor, unless evicted meanwhile.
+			if _, err := os.Stat(filename); err == nil {
+				c.disk.add(&cacheEntry{key: name, size: int64(len(b))})
+			}
+		}
+	}
+	c.remember(name, composite)
+	return composite, nil
+}
+
+// renderBytes renders into memory, sharing the limit on concurrent renderings.
+func (c *Compositor) renderBytes(render func(io.Writer) error) ([]byte, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RemoveIf removes cached files, whose name matches, from disk and memory.
+// It returns the number of removed files.
+func (c *Compositor) RemoveIf(match func(name string) bool) (int, error) {
+	fis, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var removed int
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") || !match(fi.Name()) {
+			continue
+		}
+		c.disk.remove(fi.Name())
+		c.memory.remove(fi.Name())
+		if err := os.Remove(filepath.Join(c.Dir, fi.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Stats returns the current cache statistics.
+func (c *Compositor) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Files, stats.Bytes = c.disk.len(), c.disk.size
+	stats.MemoryEntries, stats.MemoryBytes = c.memory.len(), c.memory.size
+	return stats
+}
+
+// Warm renders the given variants of the given combinations, which are not
+// cached yet, using all workers. It returns the number of rendered composites.
+func (c *Compositor) Warm(slots Slots, cids []CompositeID, variants []Variant) (int, error) {
+	type job struct {
+		cid CompositeID
+		v   Variant
+	}
+	var (
+		queue = make(chan job)
+		errs  = make(chan error, len(cids)*len(variants))
+		wg    sync.WaitGroup
+	)
+	before := c.Stats().Misses
+	for i := 0; i < cap(c.sem); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				if _, err := c.File(slots, j.cid, j.v); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, cid := range cids {
+		for _, v := range variants {
+			queue <- job{cid, v}
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+	n := int(c.Stats().Misses - before)
+	// The first error, if any.
+	return n, <-errs
+}
@@ -0,0 +1,55 @@
+package dvmweb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCompositorGetEvicted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvmweb-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A single file on disk and no memory tier, so renderings constantly
+	// evict files, which other callers are about to read.
+	c, err := NewCompositor(dir, 4, CacheLimits{MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				name := fmt.Sprintf("%d.txt", (i+j)%16)
+				composite, err := c.get(name, func(w io.Writer) error {
+					_, err := io.WriteString(w, name)
+					return err
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if string(composite.Data) != name {
+					errs <- fmt.Errorf("got %q, want %q", composite.Data, name)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if stats := c.Stats(); stats.Files != 1 {
+		t.Errorf("got %d files, want 1", stats.Files)
+	}
+}
@@ -0,0 +1,371 @@
+package dvmweb
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gorilla/mux"
+)
+
+// compositeCacheControl allows clients to keep composites for a day. The URL
+// of a composite stays the same, if a source image is replaced, so clients
+// need to revalidate eventually, which is cheap with the entity tag.
+const compositeCacheControl = "public, max-age=86400"
+
+// renderComposite pastes the images of a combination into a single image,
+// one tile per slot, scaled to the given width.
+func renderComposite(slots Slots, cid CompositeID, width int) (image.Image, error) {
+	w, h := slots.CanvasSize()
+	scale := float64(width) / float64(w)
+	dst := imaging.New(width, int(math.Round(float64(h)*scale)), color.NRGBA{0, 0, 0, 0})
+	for i, cimg := range cid.Images {
+		img, err := imaging.Open(cimg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open image at %s: %v", cimg.Path, err)
+		}
+		img = imaging.Resize(img, 0, int(math.Round(TileHeight*scale)), imaging.Lanczos)
+		dst = imaging.Paste(dst, img, image.Pt(int(math.Round(float64(TileWidth*i)*scale)), 0))
+	}
+	return dst, nil
+}
+
+// ParseWidths parses a comma separated list of composite widths in pixels,
+// e.g. "480,960,1920".
+func ParseWidths(s string) (widths []int, err error) {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		w, err := strconv.Atoi(v)
+		if err != nil || w < 1 {
+			return nil, fmt.Errorf("invalid composite width: %q", v)
+		}
+		widths = append(widths, w)
+	}
+	sort.Ints(widths)
+	return widths, nil
+}
+
+// AllowedWidths returns the widths composites are available in: the given
+// widths or half, native and double size of the slot layout, if none are
+// given. The native width is always allowed.
+func AllowedWidths(slots Slots, widths []int) []int {
+	native, _ := slots.CanvasSize()
+	if len(widths) == 0 {
+		return []int{native / 2, native, native * 2}
+	}
+	for _, w := range widths {
+		if w == native {
+			return widths
+		}
+	}
+	widths = append([]int{native}, widths...)
+	sort.Ints(widths)
+	return widths
+}
+
+// compositeVariant returns the requested variant of a composite and whether it
+// is available. The width is given as scale, e.g. /c/010203@2x.jpg, or as
+// parameter, e.g. /c/010203.jpg?w=480. For .jpg, the format is negotiated,
+// other extensions name the format, .jpeg is always JPEG.
+func (h *Handler) compositeVariant(r *http.Request, slots Slots) (v Variant, ok bool) {
+	vars := mux.Vars(r)
+	native, _ := slots.CanvasSize()
+	v.Width = native
+	if s := vars["scale"]; s != "" {
+		scale, err := strconv.Atoi(s)
+		if err != nil {
+			return v, false
+		}
+		v.Width = native * scale
+	}
+	if s := r.URL.Query().Get("w"); s != "" {
+		w, err := strconv.Atoi(s)
+		if err != nil {
+			return v, false
+		}
+		v.Width = w
+	}
+	switch v.Format = Format(vars["ext"]); v.Format {
+	case "", JPEG:
+		v.Format = negotiateFormat(r.Header.Get("Accept"), h.Compositor.Encoding.Available(WebP))
+	case "jpeg":
+		// Never negotiated, e.g. for feed enclosures, which name the type.
+		v.Format = JPEG
+	default:
+		if !h.Compositor.Encoding.Available(v.Format) {
+			return v, false
+		}
+	}
+	for _, w := range AllowedWidths(slots, h.CompositeWidths) {
+		if w == v.Width {
+			return v, true
+		}
+	}
+	return v, false
+}
+
+// srcset returns the srcset attribute value for a combination, listing all
+// allowed widths.
+func (h *Handler) srcset(slots Slots, iid string) string {
+	native, _ := slots.CanvasSize()
+	var candidates []string
+	for _, w := range AllowedWidths(slots, h.CompositeWidths) {
+		if w == native {
+			candidates = append(candidates, fmt.Sprintf("/c/%s.jpg %dw", iid, w))
+		} else {
+			candidates = append(candidates, fmt.Sprintf("/c/%s.jpg?w=%d %dw", iid, w, w))
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// compositeModTime returns the latest modification time of the source
+// images of a combination.
+func compositeModTime(cid CompositeID) (t time.Time) {
+	for _, img := range cid.Images {
+		if img.modTime.After(t) {
+			t = img.modTime
+		}
+	}
+	return t
+}
+
+// Compositor renders composite images into a cache directory. Concurrent
+// requests for the same combination share a single rendering and the number
+// of simultaneous renderings is bounded, so a crawler cannot exhaust the CPU.
+// The cache is bounded as well, see CacheLimits.
+type Compositor struct {
+	Dir      string
+	Limits   CacheLimits
+	Encoding EncodeOptions
+
+	mu      sync.Mutex
+	pending map[string]*rendering // Keyed by cache file name.
+	sem     chan struct{}
+	disk    *lru // Files in Dir.
+	memory  *lru // Hot composites with data.
+	stats   CacheStats
+}
+
+// rendering is a composite in progress, done is closed once err is set.
+type rendering struct {
+	done chan struct{}
+	err  error
+}
+
+// NewCompositor creates a compositor for a cache directory, running at most
+// workers renderings at once. Existing files are taken into the cache, the
+// least recently modified are evicted, if there are too many.
+func NewCompositor(dir string, workers int, limits CacheLimits) (*Compositor, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	c := &Compositor{
+		Dir:     dir,
+		Limits:  limits,
+		pending: make(map[string]*rendering),
+		sem:     make(chan struct{}, workers),
+		disk:    newLRU(),
+		memory:  newLRU(),
+	}
+	if err := c.scan(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compositeName returns the cache file name of a composite variant, e.g.
+// 010203.jpg for the native width of the slots or 010203-w480.webp.
+func compositeName(slots Slots, cid CompositeID, v Variant) string {
+	if native, _ := slots.CanvasSize(); v.Width == native {
+		return fmt.Sprintf("%s.%s", cid, v.Format)
+	}
+	return fmt.Sprintf("%s-w%d.%s", cid, v.Width, v.Format)
+}
+
+// compositeNameIdentifier returns the combination of a cache file name.
+func compositeNameIdentifier(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.Index(name, "-"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// renderer returns a function, which writes a composite variant.
+func (c *Compositor) renderer(slots Slots, cid CompositeID, v Variant) func(io.Writer) error {
+	return func(w io.Writer) error {
+		dst, err := renderComposite(slots, cid, v.Width)
+		if err != nil {
+			return err
+		}
+		return c.Encoding.encode(w, dst, v.Format)
+	}
+}
+
+// File returns the path to the cached composite variant, which is created, if
+// it does not exist yet.
+func (c *Compositor) File(slots Slots, cid CompositeID, v Variant) (string, error) {
+	filename, _, err := c.file(compositeName(slots, cid, v), c.renderer(slots, cid, v))
+	return filename, err
+}
+
+// file returns the path to a cache file, which is created with render, if it
+// does not exist yet. Fresh is true, if the file has just been rendered, by
+// this or a concurrent call.
+func (c *Compositor) file(name string, render func(io.Writer) error) (filename string, fresh bool, err error) {
+	filename = filepath.Join(c.Dir, name)
+	if _, err := os.Stat(filename); err == nil {
+		return filename, false, nil
+	}
+	c.mu.Lock()
+	if r, ok := c.pending[name]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		<-r.done
+		return filename, true, r.err
+	}
+	r := &rendering{done: make(chan struct{})}
+	c.pending[name] = r
+	c.mu.Unlock()
+
+	size, err := c.render(filename, render)
+	r.err = err
+
+	c.mu.Lock()
+	delete(c.pending, name)
+	if err == nil && size > 0 {
+		c.stats.Misses++
+		c.disk.add(&cacheEntry{key: name, size: size})
+		c.evict()
+	}
+	c.mu.Unlock()
+	close(r.done)
+	return filename, size > 0, err
+}
+
+// render writes a composite to a temporary file in the cache directory and
+// renames it, so readers never see a partially written file. It returns the
+// size of the new file, zero if it already existed.
+func (c *Compositor) render(filename string, render func(io.Writer) error) (int64, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	// Another rendering might have finished, while we were waiting.
+	if _, err := os.Stat(filename); err == nil {
+		return 0, nil
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return 0, fmt.Errorf("cannot create cache dir at %s: %v", c.Dir, err)
+	}
+	// Dot files are skipped by cache statistics and cleanup.
+	f, err := ioutil.TempFile(c.Dir, ".render-*"+filepath.Ext(filename))
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	if err := render(f); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("cannot render %s: %v", filename, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return 0, err
+	}
+	return fi.Size(), os.Rename(f.Name(), filename)
+}
+
+// CompositeHandler serves the composite image of a combination, which is
+// created on the fly and cached on disk and in memory. Responses carry an
+// entity tag derived from the image bytes and the modification time of the
+// source images as Last-Modified, conditional requests are answered with 304.
+// Other widths than the native one are available, if listed in
+// CompositeWidths. Requests for .jpg get WebP, if the client accepts it.
+func (h *Handler) CompositeHandler(w http.ResponseWriter, r *http.Request) {
+	inv := h.App.Inventory()
+	cid, err := inv.ParseCompositeID(mux.Vars(r)["iid"])
+	if err != nil {
+		writeHeaderLogf(w, http.StatusNotFound, "cannot locate images: %v", err)
+		return
+	}
+	v, ok := h.compositeVariant(r, inv.Slots)
+	if !ok {
+		writeHeaderLogf(w, http.StatusNotFound, "composite variant not available: %s", r.URL)
+		return
+	}
+	composite, err := h.Compositor.Get(inv.Slots, cid, v)
+	if err != nil {
+		writeHeaderLog(w, http.StatusInternalServerError, err)
+		return
+	}
+	if ext := mux.Vars(r)["ext"]; ext == "" || Format(ext) == JPEG {
+		w.Header().Set("Vary", "Accept")
+	}
+	serveComposite(w, r, composite, v.Format.ContentType(), compositeModTime(cid))
+}
+
+// AnimationHandler serves a slot machine animation as GIF, which lands on the
+// requested combination. The easing curve can be chosen with a parameter,
+// e.g. /a/010203.gif?easing=quartic-out. Animations are cached like
+// composites.
+func (h *Handler) AnimationHandler(w http.ResponseWriter, r *http.Request) {
+	inv := h.App.Inventory()
+	cid, err := inv.ParseCompositeID(mux.Vars(r)["iid"])
+	if err != nil {
+		writeHeaderLogf(w, http.StatusNotFound, "cannot locate images: %v", err)
+		return
+	}
+	opts := h.Animation
+	if v := r.URL.Query().Get("easing"); v != "" {
+		if _, err := ParseEasing(v); err != nil {
+			writeHeaderLog(w, http.StatusBadRequest, err)
+			return
+		}
+		opts.Easing = v
+	}
+	anim, err := h.Compositor.Animation(inv, cid, opts)
+	if err != nil {
+		writeHeaderLog(w, http.StatusInternalServerError, err)
+		return
+	}
+	serveComposite(w, r, anim, "image/gif", compositeModTime(cid))
+}
+
+// serveComposite writes a cached image with validators and long caching
+// headers, conditional requests are answered with 304.
+func serveComposite(w http.ResponseWriter, r *http.Request, c *Composite, contentType string, modified time.Time) {
+	w.Header().Set("Cache-Control", compositeCacheControl)
+	if notModified(w, r, c.ETag, modified) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(c.Data)))
+	if r.Method == "HEAD" {
+		return
+	}
+	if _, err := w.Write(c.Data); err != nil {
+		log.Printf("failed to write composite: %v", err)
+	}
+}
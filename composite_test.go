@@ -0,0 +1,154 @@
+package dvmweb
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompositeConditional(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+
+	rec := serve(r, "GET", "/c/010203.jpg", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != compositeCacheControl {
+		t.Errorf("got Cache-Control %q", got)
+	}
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("got ETag %q, Last-Modified %q", etag, modified)
+	}
+	var cases = []struct {
+		header, value string
+		want          int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"other", ` + etag, http.StatusNotModified},
+		{"If-None-Match", `"other"`, http.StatusOK},
+		{"If-Modified-Since", modified, http.StatusNotModified},
+		{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/c/010203.jpg", nil)
+		req.Header.Set(c.header, c.value)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: %s: got %d, want %d", c.header, c.value, rec.Code, c.want)
+		}
+		if c.want == http.StatusNotModified && rec.Body.Len() > 0 {
+			t.Errorf("%s: %s: got a body with 304", c.header, c.value)
+		}
+	}
+}
+
+func TestCompositeWidth(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	h.CompositeWidths = []int{480}
+	r := NewRouter(h, testLimiters(10))
+
+	var cases = []struct {
+		target string
+		want   int
+	}{
+		{"/c/010203.jpg", http.StatusOK},
+		{"/c/010203.jpg?w=960", http.StatusOK},
+		{"/c/010203.jpg?w=480", http.StatusOK},
+		{"/c/010203.jpg?w=481", http.StatusNotFound},
+		{"/c/010203.jpg?w=1920", http.StatusNotFound},
+		{"/c/010203.jpg?w=0", http.StatusNotFound},
+		{"/c/010203.jpg?w=-480", http.StatusNotFound},
+		{"/c/010203.jpg?w=abc", http.StatusNotFound},
+		{"/c/010203@2x.jpg", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if rec := serve(r, "GET", c.target, nil); rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.target, rec.Code, c.want)
+		}
+	}
+}
+
+func TestAllowedWidths(t *testing.T) {
+	var cases = []struct {
+		widths []int
+		want   []int
+	}{
+		{nil, []int{480, 960, 1920}},
+		{[]int{480}, []int{480, 960}},
+		{[]int{960, 1920}, []int{960, 1920}},
+		{[]int{320, 2880}, []int{320, 960, 2880}},
+	}
+	for _, c := range cases {
+		if got := AllowedWidths(DefaultSlots, c.widths); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v, want %v", c.widths, got, c.want)
+		}
+	}
+	narrow := Slots{{Category: "people", Width: 2}}
+	if got := AllowedWidths(narrow, nil); !reflect.DeepEqual(got, []int{160, 320, 640}) {
+		t.Errorf("single slot: got %v", got)
+	}
+}
+
+// srcsetPattern extracts the srcset attribute of a page.
+var srcsetPattern = regexp.MustCompile(`srcset="([^"]*)"`)
+
+func TestSrcsetWidthsServed(t *testing.T) {
+	for _, widths := range [][]int{nil, {480}, {320, 2880}} {
+		h, cleanup := newTestHandler(t)
+		h.CompositeWidths = widths
+		r := NewRouter(h, testLimiters(10))
+
+		m := srcsetPattern.FindStringSubmatch(serve(r, "GET", "/r/010203", nil).Body.String())
+		if m == nil {
+			cleanup()
+			t.Fatalf("%v: no srcset on read page", widths)
+		}
+		listed := make(map[int]bool)
+		for _, candidate := range strings.Split(m[1], ", ") {
+			fields := strings.Fields(candidate)
+			if len(fields) != 2 || !strings.HasSuffix(fields[1], "w") {
+				t.Errorf("%v: malformed candidate %q", widths, candidate)
+				continue
+			}
+			w, err := strconv.Atoi(strings.TrimSuffix(fields[1], "w"))
+			if err != nil {
+				t.Errorf("%v: malformed candidate %q", widths, candidate)
+				continue
+			}
+			listed[w] = true
+			rec := serve(r, "GET", fields[0], nil)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%v: %s: got %d, want 200", widths, fields[0], rec.Code)
+				continue
+			}
+			if cfg, _, err := image.DecodeConfig(rec.Body); err != nil || cfg.Width != w {
+				t.Errorf("%v: %s: got width %d, %v, want %d", widths, fields[0], cfg.Width, err, w)
+			}
+		}
+		if want := AllowedWidths(DefaultSlots, widths); len(listed) != len(want) {
+			t.Errorf("%v: got %d candidates, want %d", widths, len(listed), len(want))
+		}
+		for _, w := range []int{240, 320, 479, 480, 481, 960, 1920, 2880} {
+			if listed[w] {
+				continue
+			}
+			target := fmt.Sprintf("/c/010203.jpg?w=%d", w)
+			if rec := serve(r, "GET", target, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("%v: %s: got %d, want 404", widths, target, rec.Code)
+			}
+		}
+		cleanup()
+	}
+}
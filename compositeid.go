@@ -0,0 +1,61 @@
+package dvmweb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errInvalidImage is returned, if a story refers to an unknown image.
+var errInvalidImage = errors.New("invalid image id")
+
+// CompositeID identifies a combination of images, one per slot, e.g. 010203
+// for artifact 01, people 02 and landscape 03. It is only valid together with
+// the inventory it was parsed with.
+type CompositeID struct {
+	Images []CategorizedImage // In slot order.
+}
+
+// String returns the identifier as used in URLs and the database.
+func (c CompositeID) String() string {
+	var sb strings.Builder
+	for _, img := range c.Images {
+		sb.WriteString(img.Identifier)
+	}
+	return sb.String()
+}
+
+// validImageID checks the format of a composite identifier, without looking
+// at the inventory. This is what the database enforces as well.
+func validImageID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCompositeID parses an identifier and checks, that each slot refers to
+// an existing image.
+func (inv *Inventory) ParseCompositeID(s string) (CompositeID, error) {
+	if !validImageID(s) {
+		return CompositeID{}, fmt.Errorf("%v: %q", errInvalidImage, s)
+	}
+	ids, err := inv.Slots.Split(s)
+	if err != nil {
+		return CompositeID{}, err
+	}
+	var c CompositeID
+	for i, slot := range inv.Slots {
+		img, err := inv.ByCategoryAndIdentifier(slot.Category, ids[i])
+		if err != nil {
+			return CompositeID{}, err
+		}
+		c.Images = append(c.Images, *img)
+	}
+	return c, nil
+}
@@ -0,0 +1,74 @@
+package dvmweb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Easing maps the elapsed fraction of an animation to the fraction of the
+// distance covered, both in [0, 1]. The curves follow docs/easing.py.
+type Easing func(t float64) float64
+
+// easings are the available curves by name.
+var easings = map[string]Easing{
+	"linear": func(t float64) float64 { return t },
+	"quadratic-in": func(t float64) float64 {
+		return t * t
+	},
+	"quadratic-out": func(t float64) float64 {
+		return -t * (t - 2)
+	},
+	"quadratic-in-out": func(t float64) float64 {
+		if t *= 2; t < 1 {
+			return t * t / 2
+		}
+		t--
+		return -(t*(t-2) - 1) / 2
+	},
+	"cubic-in": func(t float64) float64 {
+		return t * t * t
+	},
+	"cubic-out": func(t float64) float64 {
+		t--
+		return t*t*t + 1
+	},
+	"cubic-in-out": func(t float64) float64 {
+		if t *= 2; t < 1 {
+			return t * t * t / 2
+		}
+		t -= 2
+		return (t*t*t + 2) / 2
+	},
+	"quartic-in": func(t float64) float64 {
+		return t * t * t * t
+	},
+	"quartic-out": func(t float64) float64 {
+		t--
+		return -(t*t*t*t - 1)
+	},
+	"quartic-in-out": func(t float64) float64 {
+		if t *= 2; t < 1 {
+			return t * t * t * t / 2
+		}
+		t -= 2
+		return -(t*t*t*t - 2) / 2
+	},
+}
+
+// EasingNames returns the names of all easing curves.
+func EasingNames() (names []string) {
+	for name := range easings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseEasing returns an easing curve by name, e.g. "cubic-out".
+func ParseEasing(name string) (Easing, error) {
+	if e, ok := easings[name]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown easing %q, want one of: %s", name, strings.Join(EasingNames(), ", "))
+}
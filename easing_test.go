@@ -0,0 +1,59 @@
+package dvmweb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEasing(t *testing.T) {
+	var cases = []struct {
+		name string
+		t    float64
+		want float64
+	}{
+		{"linear", 0.25, 0.25},
+		{"quadratic-in", 0.5, 0.25},
+		{"quadratic-out", 0.5, 0.75},
+		{"quadratic-in-out", 0.25, 0.125},
+		{"quadratic-in-out", 0.75, 0.875},
+		{"cubic-in", 0.5, 0.125},
+		{"cubic-out", 0.5, 0.875},
+		{"cubic-in-out", 0.25, 0.0625},
+		{"cubic-in-out", 0.75, 0.9375},
+		{"quartic-in", 0.5, 0.0625},
+		{"quartic-out", 0.5, 0.9375},
+		{"quartic-in-out", 0.25, 0.03125},
+		{"quartic-in-out", 0.75, 0.96875},
+	}
+	for _, c := range cases {
+		ease, err := ParseEasing(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ease(c.t); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s(%v): got %v, want %v", c.name, c.t, got, c.want)
+		}
+	}
+}
+
+func TestEasingCurves(t *testing.T) {
+	for _, name := range EasingNames() {
+		ease, _ := ParseEasing(name)
+		if got := ease(0); math.Abs(got) > 1e-9 {
+			t.Errorf("%s(0): got %v, want 0", name, got)
+		}
+		if got := ease(1); math.Abs(got-1) > 1e-9 {
+			t.Errorf("%s(1): got %v, want 1", name, got)
+		}
+		// Reels never move backwards.
+		for i := 1; i <= 100; i++ {
+			if a, b := ease(float64(i-1)/100), ease(float64(i)/100); b < a {
+				t.Errorf("%s: decreases at %v", name, float64(i)/100)
+				break
+			}
+		}
+	}
+	if _, err := ParseEasing("bounce"); err == nil {
+		t.Error("unknown easing accepted")
+	}
+}
@@ -0,0 +1,314 @@
+package dvmweb
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// feedSize is the number of stories in a feed.
+const feedSize = 50
+
+// feedTitle is the title of all feeds.
+const feedTitle = "Die virtuelle Mittagsfrau"
+
+// atomFeed is an Atom 1.0 feed, RFC 4287.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int    `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+// rssFeed is a RSS 2.0 feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int    `xml:"length,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	PubDate     string        `xml:"pubDate"`
+	GUID        rssGUID       `xml:"guid"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+// baseURL returns the configured base URL or derives it from the request.
+func (h *Handler) baseURL(r *http.Request) string {
+	if h.BaseURL != "" {
+		return strings.TrimRight(h.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// storyTitle is used as entry title in feeds.
+func storyTitle(s Story) string {
+	return fmt.Sprintf("Geschichte #%d zu Bild %s", s.Identifier, s.ImageIdentifier)
+}
+
+// feedUpdated returns the creation date of the newest story, the current
+// time for an empty feed.
+func feedUpdated(stories []Story) (t time.Time) {
+	for _, s := range stories {
+		if s.Created.After(t) {
+			t = s.Created
+		}
+	}
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
+
+// feedEnclosure is the composite of a story, always as JPEG, since the
+// enclosure names type and length. Ok is false, if the combination is not
+// available anymore.
+type feedEnclosure struct {
+	URL    string
+	Length int
+	ok     bool
+}
+
+// enclosures returns the composite of each combination of the stories, so
+// each is rendered once.
+func (h *Handler) enclosures(inv *Inventory, base string, stories []Story) map[string]feedEnclosure {
+	native, _ := inv.Slots.CanvasSize()
+	m := make(map[string]feedEnclosure)
+	for _, s := range stories {
+		if _, ok := m[s.ImageIdentifier]; ok {
+			continue
+		}
+		cid, err := inv.ParseCompositeID(s.ImageIdentifier)
+		if err != nil {
+			m[s.ImageIdentifier] = feedEnclosure{}
+			continue
+		}
+		c, err := h.Compositor.Get(inv.Slots, cid, Variant{Width: native, Format: JPEG})
+		if err != nil {
+			log.Printf("feed enclosure %s: %v", s.ImageIdentifier, err)
+			m[s.ImageIdentifier] = feedEnclosure{}
+			continue
+		}
+		m[s.ImageIdentifier] = feedEnclosure{
+			URL:    fmt.Sprintf("%s/c/%s.jpeg", base, s.ImageIdentifier),
+			Length: len(c.Data),
+			ok:     true,
+		}
+	}
+	return m
+}
+
+// notModified sets validators and answers conditional requests with 304.
+// It returns true, if the response has been written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, v := range strings.Split(inm, ",") {
+			if v = strings.TrimSpace(v); v == etag || v == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+		if !modified.Truncate(time.Second).After(ims) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// feedStories returns the stories for a feed, depending on the route: all,
+// for an image identifier or filtered by language parameter.
+func (h *Handler) feedStories(r *http.Request, inv *Inventory) (stories []Story, self string, err error) {
+	if iid := mux.Vars(r)["iid"]; iid != "" {
+		cid, err := inv.ParseCompositeID(iid)
+		if err != nil {
+			return nil, "", errInvalidImage
+		}
+		iid = cid.String()
+		stories, err = h.App.Store.StoriesByImage(iid)
+		if len(stories) > feedSize {
+			stories = stories[:feedSize]
+		}
+		return stories, fmt.Sprintf("/r/%s", iid), err
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		stories, err = h.App.Store.StoriesByLanguage(lang, feedSize)
+		return stories, "/", err
+	}
+	stories, err = h.App.Store.RecentStories(feedSize, 0)
+	return stories, "/", err
+}
+
+// AtomHandler renders stories as an Atom feed.
+func (h *Handler) AtomHandler(w http.ResponseWriter, r *http.Request) {
+	inv := h.App.Inventory()
+	stories, self, err := h.feedStories(r, inv)
+	if err == errInvalidImage {
+		h.NotFoundHandler(w, r)
+		return
+	}
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	var (
+		base       = h.baseURL(r)
+		enclosures = h.enclosures(inv, base, stories)
+	)
+	feed := atomFeed{
+		Title:   feedTitle,
+		ID:      base + r.URL.RequestURI(),
+		Updated: feedUpdated(stories).UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + r.URL.RequestURI()},
+			{Rel: "alternate", Type: "text/html", Href: base + self},
+		},
+		Author: atomAuthor{Name: feedTitle},
+	}
+	for _, s := range stories {
+		entry := atomEntry{
+			Title:   storyTitle(s),
+			ID:      fmt.Sprintf("%s/s/%d", base, s.Identifier),
+			Updated: s.Created.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Rel: "alternate", Type: "text/html", Href: fmt.Sprintf("%s/s/%d", base, s.Identifier)},
+			},
+			Content: atomContent{Type: "text", Body: s.Text},
+		}
+		if e := enclosures[s.ImageIdentifier]; e.ok {
+			entry.Links = append(entry.Links, atomLink{
+				Rel: "enclosure", Type: JPEG.ContentType(), Href: e.URL, Length: e.Length,
+			})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	writeFeed(w, r, "application/atom+xml; charset=utf-8", feed)
+}
+
+// RSSHandler renders stories as a RSS 2.0 feed.
+func (h *Handler) RSSHandler(w http.ResponseWriter, r *http.Request) {
+	inv := h.App.Inventory()
+	stories, self, err := h.feedStories(r, inv)
+	if err == errInvalidImage {
+		h.NotFoundHandler(w, r)
+		return
+	}
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	var (
+		base       = h.baseURL(r)
+		enclosures = h.enclosures(inv, base, stories)
+	)
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          base + self,
+			Description:   "Neue Geschichten aus der Flachsmaschine.",
+			LastBuildDate: feedUpdated(stories).UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, s := range stories {
+		link := fmt.Sprintf("%s/s/%d", base, s.Identifier)
+		item := rssItem{
+			Title:       storyTitle(s),
+			Link:        link,
+			Description: s.Text,
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+		}
+		if e := enclosures[s.ImageIdentifier]; e.ok {
+			item.Enclosure = &rssEnclosure{URL: e.URL, Type: JPEG.ContentType(), Length: e.Length}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	writeFeed(w, r, "application/rss+xml; charset=utf-8", feed)
+}
+
+// writeFeed writes a feed as XML document. The entity tag is derived from the
+// document, so it changes with any edit or moderation of a story. There is no
+// Last-Modified, since the creation dates of the stories do not reflect these
+// changes.
+func writeFeed(w http.ResponseWriter, r *http.Request, contentType string, v interface{}) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "cannot encode feed: %v", err)
+		return
+	}
+	b = append([]byte(xml.Header), b...)
+	if notModified(w, r, fmt.Sprintf(`"%x"`, sha1.Sum(b)), time.Time{}) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(b); err != nil {
+		log.Printf("write failed: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package dvmweb
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeeds(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	h.BaseURL = "https://example.com"
+	r := NewRouter(h, testLimiters(10))
+	for _, s := range []Story{
+		{ImageIdentifier: "010203", Text: "Ein Feld voller Flachs.", Language: "ger", Status: StatusVisible},
+		{ImageIdentifier: "040506", Text: "Pólo połne lena.", Language: "hsb", Status: StatusVisible},
+		// An image no longer in the inventory, without enclosure.
+		{ImageIdentifier: "999999", Text: "Verschwunden.", Language: "ger", Status: StatusVisible},
+	} {
+		if _, err := h.App.Store.InsertStory(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jpeg := serve(r, "GET", "/c/010203.jpeg", nil)
+	if jpeg.Code != http.StatusOK || jpeg.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("enclosure: got %d, %s", jpeg.Code, jpeg.Header().Get("Content-Type"))
+	}
+
+	rec := serve(r, "GET", "/feed.atom", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("atom: got %d", rec.Code)
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if len(atom.Entries) != 3 {
+		t.Fatalf("atom: got %d entries, want 3", len(atom.Entries))
+	}
+	var enclosures int
+	for _, e := range atom.Entries {
+		for _, l := range e.Links {
+			if l.Rel != "enclosure" {
+				continue
+			}
+			enclosures++
+			if strings.HasSuffix(l.Href, "/c/010203.jpeg") && l.Length != jpeg.Body.Len() {
+				t.Errorf("atom: got enclosure length %d, want %d", l.Length, jpeg.Body.Len())
+			}
+		}
+	}
+	if enclosures != 2 {
+		t.Errorf("atom: got %d enclosures, want 2", enclosures)
+	}
+
+	rec = serve(r, "GET", "/feed.rss?lang=ger", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rss: got %d", rec.Code)
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Fatalf("rss: got %d items, want 2", len(rss.Channel.Items))
+	}
+	for _, item := range rss.Channel.Items {
+		e := item.Enclosure
+		switch {
+		case strings.Contains(item.Link, "/s/3"):
+			if e != nil {
+				t.Errorf("rss: got enclosure %+v for missing image", e)
+			}
+		case e == nil:
+			t.Errorf("rss: %s: missing enclosure", item.Link)
+		case e.URL != "https://example.com/c/010203.jpeg" || e.Type != "image/jpeg" || e.Length != jpeg.Body.Len():
+			t.Errorf("rss: got enclosure %+v, want length %d", e, jpeg.Body.Len())
+		}
+	}
+
+	if rec := serve(r, "GET", "/r/999999/feed.atom", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown image: got %d, want 404", rec.Code)
+	}
+}
+
+func TestFeedETag(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+	id, err := h.App.Store.InsertStory(Story{ImageIdentifier: "010203", Text: "Flachs.", Status: StatusVisible})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// conditional requests a feed with an entity tag.
+	conditional := func(target, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, target := range []string{"/feed.atom", "/feed.rss"} {
+		etag := serve(r, "GET", target, nil).Header().Get("ETag")
+		if rec := conditional(target, etag); rec.Code != http.StatusNotModified {
+			t.Fatalf("%s: got %d, want 304", target, rec.Code)
+		}
+	}
+	etags := map[string]string{
+		"/feed.atom": serve(r, "GET", "/feed.atom", nil).Header().Get("ETag"),
+		"/feed.rss":  serve(r, "GET", "/feed.rss", nil).Header().Get("ETag"),
+	}
+	if etags["/feed.atom"] == etags["/feed.rss"] {
+		t.Error("atom and rss share an entity tag")
+	}
+	for _, change := range []struct {
+		name  string
+		apply func() error
+	}{
+		{"edit", func() error {
+			story, err := h.App.Store.StoryByID(int(id))
+			if err != nil {
+				return err
+			}
+			story.Text = "Flachs, bearbeitet."
+			return h.App.Store.UpdateStory(*story, "test")
+		}},
+		{"hide", func() error {
+			return h.App.Store.Moderate(int(id), ActionHide, "test")
+		}},
+	} {
+		if err := change.apply(); err != nil {
+			t.Fatal(err)
+		}
+		for target, etag := range etags {
+			rec := conditional(target, etag)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: %s: got %d, want 200", change.name, target, rec.Code)
+			}
+			etags[target] = rec.Header().Get("ETag")
+		}
+	}
+}
+
+func TestFeedEmpty(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+	var atom atomFeed
+	if err := xml.Unmarshal(serve(r, "GET", "/feed.atom", nil).Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := time.Parse(time.RFC3339, atom.Updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(updated) > time.Minute {
+		t.Errorf("empty feed: got updated %s", atom.Updated)
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(serve(r, "GET", "/feed.rss", nil).Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	if rss.Channel.LastBuildDate == "" || strings.HasPrefix(rss.Channel.LastBuildDate, "Mon, 01 Jan 0001") {
+		t.Errorf("empty feed: got lastBuildDate %q", rss.Channel.LastBuildDate)
+	}
+}
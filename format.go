@@ -0,0 +1,167 @@
+package dvmweb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Format is an output format of composite images, named by file extension.
+type Format string
+
+// Supported formats. Go can only encode baseline JPEG and PNG, WebP and
+// progressive JPEG require external tools, see EncodeOptions.
+const (
+	JPEG Format = "jpg"
+	PNG  Format = "png"
+	WebP Format = "webp"
+)
+
+// ContentType returns the MIME type of a format.
+func (f Format) ContentType() string {
+	switch f {
+	case PNG:
+		return "image/png"
+	case WebP:
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// Variant is a composite in a given width and format.
+type Variant struct {
+	Width  int
+	Format Format
+}
+
+// EncodeOptions configure the encoding of composites. Changed options only
+// apply to new composites, clear the cache to re-encode existing ones.
+type EncodeOptions struct {
+	JPEGQuality int    // 1-100, imaging default if zero.
+	WebPQuality int    // 0-100, passed to cwebp.
+	CWebP       string // Path to cwebp, WebP is not available, if empty.
+	JPEGTran    string // Path to jpegtran, used for progressive JPEG, if set.
+}
+
+// Available returns true, if composites can be encoded in a format.
+func (o EncodeOptions) Available(f Format) bool {
+	switch f {
+	case JPEG, PNG:
+		return true
+	case WebP:
+		return o.CWebP != ""
+	}
+	return false
+}
+
+// encode writes an image in the given format.
+func (o EncodeOptions) encode(w io.Writer, img image.Image, f Format) error {
+	switch f {
+	case PNG:
+		return png.Encode(w, img)
+	case WebP:
+		return o.encodeWebP(w, img)
+	}
+	var opts []imaging.EncodeOption
+	if o.JPEGQuality > 0 {
+		opts = append(opts, imaging.JPEGQuality(o.JPEGQuality))
+	}
+	if o.JPEGTran == "" {
+		return imaging.Encode(w, img, imaging.JPEG, opts...)
+	}
+	// Lossless conversion of the baseline JPEG to progressive.
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, opts...); err != nil {
+		return err
+	}
+	return runEncoder(w, &buf, o.JPEGTran, "-progressive", "-optimize", "-copy", "none")
+}
+
+// encodeWebP passes the image as PNG to cwebp, which reads files only.
+func (o EncodeOptions) encodeWebP(w io.Writer, img image.Image) error {
+	f, err := ioutil.TempFile("", "dvmweb-cwebp-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return runEncoder(w, nil, o.CWebP, "-quiet", "-q", strconv.Itoa(o.WebPQuality), f.Name(), "-o", "-")
+}
+
+// runEncoder runs an external encoder with input on stdin and writes its
+// output to w.
+func runEncoder(w io.Writer, r io.Reader, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, w, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// negotiateFormat returns WebP, if it is available, explicitly listed in an
+// Accept header and accepted at least as much as JPEG, and JPEG otherwise.
+// Wildcards do not select WebP, older browsers send them, but cannot decode
+// it. PNG is only served, if requested by file extension, since some browsers
+// list it in Accept for all images.
+func negotiateFormat(accept string, webp bool) Format {
+	if !webp || accept == "" {
+		return JPEG
+	}
+	q, exact := acceptQuality(accept, WebP.ContentType())
+	if jq, _ := acceptQuality(accept, JPEG.ContentType()); exact && q > 0 && q >= jq {
+		return WebP
+	}
+	return JPEG
+}
+
+// acceptQuality returns the quality value of a media type in an Accept
+// header, considering exact matches first, then type/* and */*, and whether
+// the media type is listed.
+func acceptQuality(accept, mediaType string) (q float64, listed bool) {
+	var exact, partial, any = -1.0, -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		v := 1.0
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					v = f
+				}
+			}
+		}
+		switch {
+		case name == mediaType:
+			exact = v
+		case name == "*/*":
+			any = v
+		case strings.HasSuffix(name, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(name, "*")):
+			partial = v
+		}
+	}
+	for _, v := range []float64{exact, partial, any} {
+		if v >= 0 {
+			return v, exact >= 0
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package dvmweb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	var cases = []struct {
+		accept string
+		webp   bool // Whether cwebp is available.
+		want   Format
+	}{
+		{"", true, JPEG},
+		{"image/webp,*/*", true, WebP},
+		{"image/webp,*/*", false, JPEG},
+		{"image/webp", false, JPEG},
+		{"IMAGE/WEBP", true, WebP},
+		{"image/webp;q=0,*/*", true, JPEG},
+		{"image/webp;q=0", true, JPEG},
+		{"*/*", true, JPEG},
+		{"image/*", true, JPEG},
+		{"image/*,*/*;q=0.8", true, JPEG},
+		{"image/png", true, JPEG},
+		{"image/webp,image/jpeg", true, WebP},
+		{"image/webp;q=0.5,image/jpeg", true, JPEG},
+		{"image/webp;q=0.8,image/*;q=0.8", true, WebP},
+		{"image/webp;q=0.8,image/*;q=0.9", true, JPEG},
+		{"image/webp;q=0.8,image/jpeg;q=0.5,image/*", true, WebP},
+		{"image/webp;q=0.5,*/*", true, JPEG},
+		{"image/webp;q=bogus,image/jpeg", true, WebP},
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", true, WebP},
+	}
+	for _, c := range cases {
+		if got := negotiateFormat(c.accept, c.webp); got != c.want {
+			t.Errorf("%q (webp %v): got %s, want %s", c.accept, c.webp, got, c.want)
+		}
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	var cases = []struct {
+		accept    string
+		mediaType string
+		q         float64
+		listed    bool
+	}{
+		{"image/webp", "image/webp", 1, true},
+		{"image/webp;q=0", "image/webp", 0, true},
+		{"image/webp ; q=0.7", "image/webp", 0.7, true},
+		{"image/jpeg;q=0.3, image/*", "image/jpeg", 0.3, true},
+		{"image/*;q=0.5,*/*;q=0.1", "image/jpeg", 0.5, false},
+		{"*/*;q=0.1", "image/jpeg", 0.1, false},
+		{"text/*", "image/jpeg", 0, false},
+		{"text/html", "image/jpeg", 0, false},
+		{"", "image/jpeg", 0, false},
+	}
+	for _, c := range cases {
+		q, listed := acceptQuality(c.accept, c.mediaType)
+		if q != c.q || listed != c.listed {
+			t.Errorf("%q, %s: got %v, %v, want %v, %v", c.accept, c.mediaType, q, listed, c.q, c.listed)
+		}
+	}
+}
+
+func TestCompositeWithoutCWebP(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	h.Compositor.Encoding.CWebP = ""
+	r := NewRouter(h, testLimiters(10))
+
+	req := httptest.NewRequest("GET", "/c/010203.jpg", nil)
+	req.Header.Set("Accept", "image/webp,*/*")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("negotiated: got %d, %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec := serve(r, "GET", "/c/010203.webp", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("by extension: got %d, want 404", rec.Code)
+	}
+}
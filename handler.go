@@ -1,23 +1,21 @@
 package dvmweb
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
-	"image"
-	"image/color"
+	"html"
 	"io"
 	"log"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/gorilla/mux"
 )
 
@@ -27,6 +25,8 @@ var fmap = template.FuncMap{
 	"datefmt": func(t time.Time) string {
 		return t.Format("02.01.2006 15:04")
 	},
+	"escape": html.EscapeString,
+	"status": statusName,
 	"clip": func(s string) string {
 		if len(s) > 50 {
 			return fmt.Sprintf("%s ...", s[:50])
@@ -38,9 +38,31 @@ var fmap = template.FuncMap{
 // Handler implements HTTP request for reading, writing and rendering stories.
 // Has access to application and static directory for assets, e.g. cached images.
 type Handler struct {
-	mu  sync.Mutex // Lock app and database access.
 	App *App
 
+	// TrustedProxies may set X-Forwarded-For.
+	TrustedProxies []*net.IPNet
+
+	// SpamCheckers run before a new story is saved.
+	SpamCheckers []SpamChecker
+
+	// RouteStrategies selects the strategy for random combinations per
+	// route, "rand", "index" or "api". Uniform, if missing.
+	RouteStrategies map[string]Strategy
+
+	// BaseURL is used for absolute links in feeds, e.g.
+	// "https://dvm.example.org". Derived from the request, if empty.
+	BaseURL string
+
+	// Compositor renders and caches composite images.
+	Compositor *Compositor
+	// CompositeWidths are the widths in pixels composites are available in,
+	// besides the native width of the slot layout. Half and double the native
+	// width, if empty.
+	CompositeWidths []int
+	// Animation configures the slot machine animation.
+	Animation AnimationOptions
+
 	StaticDir    string
 	TemplatesDir string
 	Version      string
@@ -48,19 +70,21 @@ type Handler struct {
 
 // ReadHandler reads a story, given a random (image) identifier, e.g. "121403" or similar.
 func (h *Handler) ReadHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	iid := vars["iid"]
-	t, err := template.New("read.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "read.html"))
+	inv := h.App.Inventory()
+	cid, ok := h.compositeID(w, r, inv)
+	if !ok {
+		return
+	}
+	iid := cid.String()
+	inv.MarkShown(iid)
+	t, err := template.New("read.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "read.html"),
+		filepath.Join(h.TemplatesDir, "attribution.html"))
 	if t == nil || err != nil {
 		log.Printf("failed or missing template: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	var stories []Story
-	err = h.App.db.Select(&stories, `
-	SELECT id, imageid, text, language, created
-	FROM story WHERE imageid = ?
-	ORDER BY created DESC`, iid)
+	stories, err := h.App.Store.StoriesByImage(iid)
 	if err != nil {
 		log.Printf("SQL failed: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -68,9 +92,15 @@ func (h *Handler) ReadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	var data = struct {
 		RandomIdentifier string
+		Srcset           string
+		Images           []CategorizedImage
+		Video            *Video
 		Stories          []Story
 	}{
 		RandomIdentifier: iid,
+		Srcset:           h.srcset(inv.Slots, iid),
+		Images:           cid.Images,
+		Video:            inv.VideoForCombination(iid),
 		Stories:          stories,
 	}
 	if err := t.Execute(w, data); err != nil {
@@ -80,57 +110,83 @@ func (h *Handler) ReadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// VideoHandler shows a video along with the stories for its combination.
+func (h *Handler) VideoHandler(w http.ResponseWriter, r *http.Request) {
+	video := h.App.Inventory().VideoByIdentifier(mux.Vars(r)["vid"])
+	if video == nil {
+		log.Printf("no such video: %s", mux.Vars(r)["vid"])
+		h.NotFoundHandler(w, r)
+		return
+	}
+	t, err := template.New("video.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "video.html"))
+	if t == nil || err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "failed or missing template: %v", err)
+		return
+	}
+	var stories []Story
+	if video.Combination != "" {
+		if stories, err = h.App.Store.StoriesByImage(video.Combination); err != nil {
+			writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+			return
+		}
+	}
+	var data = struct {
+		Video   *Video
+		Stories []Story
+		Version string
+	}{
+		Video:   video,
+		Stories: stories,
+		Version: h.Version,
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("render failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
 // WriteHandler creates a new story.
 func (h *Handler) WriteHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	iid := vars["iid"]
+	inv := h.App.Inventory()
+	cid, ok := h.compositeID(w, r, inv)
+	if !ok {
+		return
+	}
+	iid := cid.String()
 
 	if r.Method == "POST" {
-		// Save new story to database.
-		h.mu.Lock()
-		defer h.mu.Unlock()
-
+		// Save new story to database, rate limiting happens in middleware.
 		// Parse url parameters passed, then parse the response packet for the
 		// POST body (request body) attention: If you do not call ParseForm
 		// method, the following data can not be obtained form.
 		r.ParseForm()
 
-		text := strings.TrimSpace(r.Form.Get("story"))
-		language := r.Form.Get("language") // Assume form has sane default.
-
-		if len(text) == 0 {
-			writeHeaderLog(w, http.StatusNoContent, "no content")
+		lid, err := h.saveStory(r, inv, Story{
+			ImageIdentifier: iid,
+			Text:            r.Form.Get("story"),
+			Language:        r.Form.Get("language"), // Assume form has sane default.
+		})
+		switch err {
+		case nil:
+		case errEmptyStory:
+			writeHeaderLog(w, http.StatusNoContent, err)
 			return
-		}
-		if len(text) > 10000 {
-			writeHeaderLog(w, http.StatusBadRequest, "body exceeds limit")
+		case errStoryTooLong, errSpam, errInvalidImage:
+			writeHeaderLog(w, http.StatusBadRequest, err)
 			return
-		}
-		// TODO(miku): Add spam detector from https://git.io/fhFUf.
-
-		// The ultimate rate limiter. Limits the amount postable to about
-		// 400M per day. TODO(miku): Lookup IP address and send back a "you are
-		// doing this too much" or similar.
-		time.Sleep(2 * time.Second)
-
-		stmt := `INSERT INTO story (imageid, text, language, ip, flagged) values (?, ?, ?, ?, ?)`
-		result, err := h.App.db.Exec(stmt, iid, text, language, r.RemoteAddr, false)
-		if err != nil {
+		default:
 			writeHeaderLogf(w, http.StatusInternalServerError, "insert failed: %v", err)
 			return
 		}
-		lid, err := result.LastInsertId()
-		if err != nil {
-			writeHeaderLogf(w, http.StatusInternalServerError, "failed to get last insert id: %v", err)
-			return
-		}
 		log.Printf("last insert id was: %v", lid)
 		http.Redirect(w, r, fmt.Sprintf("/r/%s", iid), http.StatusSeeOther)
 		return
 	}
 
 	// Render form.
-	t, err := template.New("write.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "write.html"))
+	t, err := template.New("write.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "write.html"),
+		filepath.Join(h.TemplatesDir, "attribution.html"))
 	if t == nil || err != nil {
 		log.Printf("failed or missing template: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -139,8 +195,12 @@ func (h *Handler) WriteHandler(w http.ResponseWriter, r *http.Request) {
 
 	var data = struct {
 		RandomIdentifier string
+		Srcset           string
+		Images           []CategorizedImage
 	}{
 		RandomIdentifier: iid,
+		Srcset:           h.srcset(inv.Slots, iid),
+		Images:           cid.Images,
 	}
 	if err := t.Execute(w, data); err != nil {
 		log.Printf("render failed: %v", err)
@@ -149,6 +209,107 @@ func (h *Handler) WriteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// maxStoryLength is the maximum length of a story in bytes.
+const maxStoryLength = 10000
+
+var (
+	errEmptyStory   = errors.New("no content")
+	errStoryTooLong = errors.New("body exceeds limit")
+	errSpam         = errors.New("rejected as spam")
+)
+
+// saveStory validates and saves a new story. Suspicious stories are saved,
+// but flagged for review.
+func (h *Handler) saveStory(r *http.Request, inv *Inventory, story Story) (int64, error) {
+	cid, err := inv.ParseCompositeID(story.ImageIdentifier)
+	if err != nil {
+		return 0, errInvalidImage
+	}
+	story.ImageIdentifier = cid.String()
+	story.Text = strings.TrimSpace(story.Text)
+	if len(story.Text) == 0 {
+		return 0, errEmptyStory
+	}
+	if len(story.Text) > maxStoryLength {
+		return 0, errStoryTooLong
+	}
+	story.IP = h.clientIP(r)
+	story.Status = StatusVisible
+	switch h.checkSpam(r, story) {
+	case Spam:
+		return 0, errSpam
+	case Suspicious:
+		story.Status = StatusFlagged
+	}
+	return h.App.Store.InsertStory(story)
+}
+
+// clientIP returns the address of the client, respecting trusted proxies.
+func (h *Handler) clientIP(r *http.Request) string {
+	return ClientIP(r, h.TrustedProxies)
+}
+
+// RateLimit wraps a handler with a per client rate limit. Clients exceeding
+// the limit get a 429 with a Retry-After header and a friendly page, or a JSON
+// error for API routes.
+func (h *Handler) RateLimit(l *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.applies(r.Method) {
+			next(w, r)
+			return
+		}
+		ip := h.clientIP(r)
+		ok, wait := l.Allow(ip)
+		if ok {
+			next(w, r)
+			return
+		}
+		seconds := int(math.Ceil(wait.Seconds()))
+		log.Printf("rate limit exceeded for %s on %s, retry in %ds", ip, r.URL.Path, seconds)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+			writeJSON(w, http.StatusTooManyRequests, apiError{
+				Error: fmt.Sprintf("rate limit exceeded, retry in %ds", seconds),
+			})
+			return
+		}
+		t, err := template.New("429.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "429.html"))
+		if t == nil || err != nil {
+			writeHeaderLogf(w, http.StatusTooManyRequests, "failed or missing template: %v", err)
+			return
+		}
+		var data = struct {
+			RetryAfter int
+		}{
+			RetryAfter: seconds,
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		if err := t.Execute(w, data); err != nil {
+			log.Printf("render failed: %v", err)
+		}
+	}
+}
+
+// checkSpam runs all configured spam checkers and returns the most severe
+// verdict. Failing checkers are logged and ignored.
+func (h *Handler) checkSpam(r *http.Request, s Story) Verdict {
+	verdict := Ham
+	for _, c := range h.SpamCheckers {
+		v, reason, err := c.CheckSpam(r, s)
+		if err != nil {
+			log.Printf("spam check failed: %v", err)
+			continue
+		}
+		if v != Ham {
+			log.Printf("spam check for %s from %s: %s (%s)", s.ImageIdentifier, s.IP, v, reason)
+		}
+		if v > verdict {
+			verdict = v
+		}
+	}
+	return verdict
+}
+
 // StoryHandler links to a single story. One image can have multiple.
 func (h *Handler) StoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -157,37 +318,43 @@ func (h *Handler) StoryHandler(w http.ResponseWriter, r *http.Request) {
 		writeHeaderLog(w, http.StatusBadRequest, err)
 		return
 	}
-	t, err := template.New("story.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "story.html"))
+	t, err := template.New("story.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "story.html"),
+		filepath.Join(h.TemplatesDir, "attribution.html"))
 	if t == nil || err != nil {
 		log.Printf("failed or missing template: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	var story Story
-	err = h.App.db.Get(&story, `
-	SELECT id, imageid, text, language, created
-	FROM story WHERE id = ? LIMIT 1`, identifier)
+	story, err := h.App.Store.StoryByID(identifier)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			io.WriteString(w, "404 Not Found")
+		if err == ErrStoryNotFound {
 			writeHeaderLogf(w, http.StatusNotFound, "no such story")
+			io.WriteString(w, "404 Not Found")
 			return
 		}
 		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
 		return
 	}
-	// No story.
-	if story.Text == "" {
-		io.WriteString(w, "404 Not Found")
+	// No story or not visible.
+	if story.Text == "" || !story.Public() {
 		writeHeaderLogf(w, http.StatusNotFound, "missing story: %d", identifier)
+		io.WriteString(w, "404 Not Found")
 		return
 	}
+	// Older stories may refer to images no longer in the inventory, show
+	// them without attribution.
+	inv := h.App.Inventory()
+	cid, _ := inv.ParseCompositeID(story.ImageIdentifier)
 	var data = struct {
 		RandomIdentifier string
+		Srcset           string
+		Images           []CategorizedImage
 		Story            Story
 	}{
 		RandomIdentifier: story.ImageIdentifier,
-		Story:            story,
+		Srcset:           h.srcset(inv.Slots, story.ImageIdentifier),
+		Images:           cid.Images,
+		Story:            *story,
 	}
 	if err := t.Execute(w, data); err != nil {
 		log.Printf("template err: %s", err)
@@ -196,6 +363,39 @@ func (h *Handler) StoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ReportHandler lets visitors report a story, which hides it until a curator
+// had a look.
+func (h *Handler) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeHeaderLog(w, http.StatusMethodNotAllowed, "report requires POST")
+		return
+	}
+	identifier, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeHeaderLog(w, http.StatusBadRequest, err)
+		return
+	}
+	story, err := h.App.Store.StoryByID(identifier)
+	if err == ErrStoryNotFound {
+		writeHeaderLogf(w, http.StatusNotFound, "no such story: %d", identifier)
+		return
+	}
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if len(reason) > 1000 {
+		reason = reason[:1000]
+	}
+	if err := h.App.Store.ReportStory(identifier, h.clientIP(r), reason); err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "report failed: %v", err)
+		return
+	}
+	log.Printf("story %d reported", identifier)
+	http.Redirect(w, r, fmt.Sprintf("/r/%s", story.ImageIdentifier), http.StatusSeeOther)
+}
+
 // AboutHandler render information about the app.
 func (h *Handler) AboutHandler(w http.ResponseWriter, r *http.Request) {
 	t, err := template.New("about.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "about.html"))
@@ -204,24 +404,36 @@ func (h *Handler) AboutHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	// Video identifier, random image identifier.
-	var vid, rid string
+	strategy, err := h.strategy(r, "index")
+	if err != nil {
+		writeHeaderLog(w, http.StatusBadRequest, err)
+		return
+	}
 
-	if vid, err = h.App.Inventory.RandomVideoIdentifier(); err != nil {
+	// Video, random image identifier.
+	var (
+		video *Video
+		rid   string
+	)
+
+	inv := h.App.Inventory()
+	if video, err = inv.RandomVideo(); err != nil {
 		writeHeaderLog(w, http.StatusInternalServerError, err)
 		return
 	}
-	if rid, err = h.App.Inventory.RandomImageIdentifier(); err != nil {
+	if rid, err = h.randomImageIdentifier(inv, strategy); err != nil {
 		writeHeaderLog(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	var data = struct {
 		RandomVideoIdentifier string
+		VideoSources          []VideoSource
 		RandomIdentifier      string
 		Version               string
 	}{
-		RandomVideoIdentifier: vid,
+		RandomVideoIdentifier: video.Identifier,
+		VideoSources:          video.Sources,
 		RandomIdentifier:      rid,
 		Version:               h.Version,
 	}
@@ -232,6 +444,18 @@ func (h *Handler) AboutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// compositeID parses the image identifier of the route. If it does not refer
+// to images in the inventory, the 404 page is rendered and ok is false.
+func (h *Handler) compositeID(w http.ResponseWriter, r *http.Request, inv *Inventory) (cid CompositeID, ok bool) {
+	cid, err := inv.ParseCompositeID(mux.Vars(r)["iid"])
+	if err != nil {
+		log.Printf("invalid image id: %v", err)
+		h.NotFoundHandler(w, r)
+		return cid, false
+	}
+	return cid, true
+}
+
 // NotFoundHandler renders a 404 page.
 func (h *Handler) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	t, err := template.New("404.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "404.html"))
@@ -240,95 +464,37 @@ func (h *Handler) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	var vid string
+	var video *Video
 
-	if vid, err = h.App.Inventory.RandomVideoIdentifier(); err != nil {
+	if video, err = h.App.Inventory().RandomVideo(); err != nil {
 		writeHeaderLog(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	var data = struct {
 		RandomVideoIdentifier string
+		VideoSources          []VideoSource
 		Version               string
 	}{
-		RandomVideoIdentifier: vid,
+		RandomVideoIdentifier: video.Identifier,
+		VideoSources:          video.Sources,
 		Version:               h.Version,
 	}
+	w.WriteHeader(http.StatusNotFound)
 	if err := t.Execute(w, data); err != nil {
 		log.Printf("render failed: %v", err)
 		return
 	}
 }
 
-// CacheImageRedirect is a helper handler, which creates an image on the fly, of just
-// redirects to the static location of the image.
-func (h *Handler) CacheImageRedirect(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	iid := vars["iid"]
-	if len(iid) != 6 {
-		writeHeaderLogf(w, http.StatusBadRequest, "six digit image id expected, got %v", iid)
-		return
-	}
-	// The cached file name.
-	filename := filepath.Join(h.StaticDir, "cache", fmt.Sprintf("%s.jpg", iid))
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		// Create cached version and put it under cache.
-
-		var cimgs []*CategorizedImage
-		var requested = []struct {
-			category string
-			imageid  string
-		}{
-			{"artifacts", iid[:2]},
-			{"people", iid[2:4]},
-			{"landscapes", iid[4:6]},
-		}
-		for _, req := range requested {
-			img, err := h.App.Inventory.ByCategoryAndIdentifier(req.category, req.imageid)
-			if err != nil {
-				writeHeaderLogf(w, http.StatusNotFound, "cannot locate %v: %v", req.category, err)
-				return
-			}
-			cimgs = append(cimgs, img)
-		}
-
-		// Resize to this height.
-		resizeHeight := 300
-		// Destination image.
-		dst := imaging.New(960, 300, color.NRGBA{0, 0, 0, 0})
-
-		// Iterate over images, resize and paste them into destination.
-		for i, cimg := range cimgs {
-			img, err := imaging.Open(cimg.Path)
-			if err != nil {
-				writeHeaderLogf(w, http.StatusInternalServerError, "cannot open image at: %v", cimg.Path)
-				return
-			}
-			img = imaging.Resize(img, 0, resizeHeight, imaging.Lanczos)
-			dst = imaging.Paste(dst, img, image.Pt(320*i, 0))
-		}
-
-		// Save to static cache dir, create the directory on the fly.
-		cacheDir := filepath.Join(h.StaticDir, "cache")
-		if err := os.MkdirAll(cacheDir, 0755); err != nil {
-			writeHeaderLogf(w, http.StatusInternalServerError, "cannot create cache dir at %s", cacheDir)
-			return
-		}
-		if err := imaging.Save(dst, filename); err != nil {
-			writeHeaderLogf(w, http.StatusInternalServerError, "cannot save image to %s", filename)
-			return
-		}
-
-		http.Redirect(w, r, fmt.Sprintf("/static/cache/%s.jpg", iid), http.StatusSeeOther)
-		return
-	}
-	// Redirect to static page.
-	http.Redirect(w, r, fmt.Sprintf("/static/cache/%s.jpg", iid), http.StatusSeeOther)
-}
-
 // RandomRead redirects to a random read page.
 func (h *Handler) RandomRead(w http.ResponseWriter, r *http.Request) {
-	iid, err := h.App.Inventory.RandomImageIdentifier()
+	strategy, err := h.strategy(r, "rand")
+	if err != nil {
+		writeHeaderLog(w, http.StatusBadRequest, err)
+		return
+	}
+	iid, err := h.randomImageIdentifier(h.App.Inventory(), strategy)
 	if err != nil {
 		writeHeaderLogf(w, http.StatusInternalServerError, "failed to find random image: %v", err)
 		return
@@ -336,6 +502,31 @@ func (h *Handler) RandomRead(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("/r/%s", iid), http.StatusSeeOther)
 }
 
+// strategy returns the strategy for random combinations on a route, which
+// can be overridden with the strategy query parameter.
+func (h *Handler) strategy(r *http.Request, route string) (Strategy, error) {
+	if v := r.URL.Query().Get("strategy"); v != "" {
+		return ParseStrategy(v)
+	}
+	if s, ok := h.RouteStrategies[route]; ok {
+		return s, nil
+	}
+	return Uniform, nil
+}
+
+// randomImageIdentifier draws a combination, story counts are only queried,
+// if the strategy needs them.
+func (h *Handler) randomImageIdentifier(inv *Inventory, s Strategy) (string, error) {
+	var counts map[string]int
+	if s.NeedsCounts() {
+		var err error
+		if counts, err = h.App.Store.StoryCounts(); err != nil {
+			return "", err
+		}
+	}
+	return inv.RandomImageIdentifierWith(s, counts)
+}
+
 // IndexHandler render the home page.
 func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	t, err := template.New("index.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "index.html"))
@@ -344,31 +535,38 @@ func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var stories []Story
-	err = h.App.db.Select(&stories, `
-	SELECT id, imageid, text, language, created
-	FROM story ORDER BY created DESC LIMIT 100`)
+	stories, err := h.App.Store.RecentStories(100, 0)
 	if err != nil {
 		writeHeaderLogf(w, http.StatusInternalServerError, "SQL failed: %v", err)
 		return
 	}
 
-	// Video identifier, random image identifier.
-	var vid, rid string
+	strategy, err := h.strategy(r, "index")
+	if err != nil {
+		writeHeaderLog(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Video, random image identifier.
+	var (
+		video *Video
+		rid   string
+	)
 
 	// For frontpage animation.
-	if vid, err = h.App.Inventory.RandomVideoIdentifier(); err != nil {
+	inv := h.App.Inventory()
+	if video, err = inv.RandomVideo(); err != nil {
 		writeHeaderLog(w, http.StatusInternalServerError, err)
 		return
 	}
 	// For fallback image.
-	if rid, err = h.App.Inventory.RandomImageIdentifier(); err != nil {
+	if rid, err = h.randomImageIdentifier(inv, strategy); err != nil {
 		writeHeaderLog(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	// Fallback to some image.
-	riws := "000000"
+	// Fallback to the random combination, which fits the configured slots.
+	riws := rid
 	if len(stories) > 0 {
 		riws = stories[rand.Intn(len(stories))].ImageIdentifier
 	}
@@ -376,12 +574,14 @@ func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	var data = struct {
 		Stories               []Story
 		RandomVideoIdentifier string
+		VideoSources          []VideoSource
 		RandomIdentifier      string
 		RandomImageWithStory  string
 		Version               string
 	}{
 		Stories:               stories,
-		RandomVideoIdentifier: vid,
+		RandomVideoIdentifier: video.Identifier,
+		VideoSources:          video.Sources,
 		RandomIdentifier:      rid,
 		RandomImageWithStory:  riws,
 		Version:               h.Version,
@@ -393,6 +593,60 @@ func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// searchQuery extracts a search query from request parameters.
+func searchQuery(r *http.Request) SearchQuery {
+	return SearchQuery{
+		Text:     strings.TrimSpace(r.URL.Query().Get("q")),
+		Language: r.URL.Query().Get("lang"),
+		Limit:    100,
+	}
+}
+
+// search runs a query and attaches highlighted snippets.
+func (h *Handler) search(q SearchQuery) ([]SearchResult, error) {
+	stories, err := h.App.Store.SearchStories(q)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, s := range stories {
+		results = append(results, SearchResult{
+			Story:   s,
+			Snippet: highlight(s.Text, q.Terms()),
+		})
+	}
+	return results, nil
+}
+
+// SearchHandler renders stories matching a query, e.g. /search?q=flachs&lang=ger.
+func (h *Handler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.New("search.html").Funcs(fmap).ParseFiles(filepath.Join(h.TemplatesDir, "search.html"))
+	if t == nil || err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "failed or missing template: %v", err)
+		return
+	}
+	q := searchQuery(r)
+	results, err := h.search(q)
+	if err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "search failed: %v", err)
+		return
+	}
+	var data = struct {
+		Query    string
+		Language string
+		Results  []SearchResult
+	}{
+		Query:    q.Text,
+		Language: q.Language,
+		Results:  results,
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("render failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
 // writeHeaderLog logs an error and writes HTTP status code to header.
 func writeHeaderLog(w http.ResponseWriter, statusCode int, v interface{}) {
 	log.Println(v)
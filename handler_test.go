@@ -0,0 +1,255 @@
+package dvmweb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// serve sends a request through the router and returns the recorded response.
+func serve(h http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestHandler returns a handler for the images and templates in this
+// repository, backed by a MemoryStore, along with a cleanup function.
+func newTestHandler(t *testing.T) (*Handler, func()) {
+	t.Helper()
+	inv, err := createInventory("static/images", "static/videos", DefaultSlots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "dvmweb-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositor, err := NewCompositor(dir, 1, CacheLimits{})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	h := &Handler{
+		App: &App{
+			Store:     NewMemoryStore(),
+			imagesDir: "static/images",
+			videosDir: "static/videos",
+			inventory: inv,
+		},
+		Compositor:   compositor,
+		Animation:    DefaultAnimationOptions,
+		StaticDir:    "static/",
+		TemplatesDir: "templates",
+		Version:      "test",
+	}
+	return h, func() { os.RemoveAll(dir) }
+}
+
+// testLimiters returns rate limiters for all routes, which allow burst
+// requests per client.
+func testLimiters(burst int) map[string]*RateLimiter {
+	limiters := make(map[string]*RateLimiter)
+	for _, name := range []string{"write", "report", "login"} {
+		limiters[name] = NewRateLimiter(Limit{Rate: 0.001, Burst: burst}, "POST")
+	}
+	return limiters
+}
+
+func TestWriteAndRead(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+
+	rec := serve(r, "POST", "/w/010203", url.Values{
+		"story":    {"Ein Feld voller <b>Flachs</b>."},
+		"language": {"ger"},
+	})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/r/010203" {
+		t.Fatalf("write: got %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, target := range []string{"/", "/r/010203", "/s/1"} {
+		rec = serve(r, "GET", target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", target, rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, "Ein Feld voller &lt;b&gt;Flachs&lt;/b&gt;.") {
+			t.Errorf("GET %s: story missing or not escaped", target)
+		}
+	}
+	rec = serve(r, "GET", "/search?q=fla", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/s/1") {
+		t.Errorf("search: got %d, story not found", rec.Code)
+	}
+}
+
+func TestWriteRejects(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+
+	var cases = []struct {
+		target string
+		story  string
+		want   int
+	}{
+		{"/w/010203", "   ", http.StatusNoContent},
+		{"/w/010203", strings.Repeat("x", maxStoryLength+1), http.StatusBadRequest},
+		{"/w/999999", "Eine Geschichte.", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := serve(r, "POST", c.target, url.Values{"story": {c.story}, "language": {"ger"}})
+		if rec.Code != c.want {
+			t.Errorf("POST %s: got %d, want %d", c.target, rec.Code, c.want)
+		}
+	}
+	if stories, _ := h.App.Store.AllStories(10, 0); len(stories) > 0 {
+		t.Errorf("got %d stories, want none", len(stories))
+	}
+}
+
+func TestReportAndDelete(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+
+	for _, text := range []string{"Die erste Geschichte.", "Die zweite Geschichte."} {
+		if rec := serve(r, "POST", "/w/010203", url.Values{"story": {text}, "language": {"ger"}}); rec.Code != http.StatusSeeOther {
+			t.Fatalf("write: got %d", rec.Code)
+		}
+	}
+	rec := serve(r, "POST", "/s/2/report", url.Values{"reason": {"spam"}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("report: got %d", rec.Code)
+	}
+	items, err := h.App.Store.ModerationQueue()
+	if err != nil || len(items) != 1 || items[0].Story.Identifier != 2 {
+		t.Fatalf("queue: got %v, %v", items, err)
+	}
+	if strings.Contains(serve(r, "GET", "/r/010203", nil).Body.String(), "Die zweite Geschichte.") {
+		t.Error("reported story still shown")
+	}
+	if err := h.App.Store.Moderate(2, ActionDelete, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(r, "GET", "/s/2", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted story: got %d, want 404", rec.Code)
+	}
+	serve(r, "POST", "/w/010203", url.Values{"story": {"Die dritte Geschichte."}, "language": {"ger"}})
+	rec = serve(r, "GET", "/s/3", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Die dritte Geschichte.") {
+		t.Errorf("new story after delete: got %d", rec.Code)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(10))
+	for _, target := range []string{"/nope", "/s/42", "/r/999999"} {
+		if rec := serve(r, "GET", target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	r := NewRouter(h, testLimiters(1))
+
+	var cases = []struct {
+		target      string
+		body        string
+		contentType string
+		json        bool
+	}{
+		{"/w/010203", "story=Eine+Geschichte.&language=ger", "application/x-www-form-urlencoded", false},
+		{"/api/v1/stories", `{"image": "010203", "text": "Eine Geschichte."}`, "application/json", true},
+	}
+	for _, c := range cases {
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("POST", c.target, strings.NewReader(c.body))
+			req.Header.Set("Content-Type", c.contentType)
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+		}
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("POST %s: got %d, want 429", c.target, rec.Code)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("POST %s: missing Retry-After", c.target)
+		}
+		isJSON := strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json")
+		if isJSON != c.json {
+			t.Errorf("POST %s: got content type %q", c.target, rec.Header().Get("Content-Type"))
+		}
+		if !c.json {
+			continue
+		}
+		var v apiError
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil || v.Error == "" {
+			t.Errorf("POST %s: got %q, %v, want JSON error", c.target, rec.Body.String(), err)
+		}
+	}
+}
+
+func TestIndexFallbackFitsSlots(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	slots := Slots{{Category: "people", Width: 2}, {Category: "landscapes", Width: 2}}
+	inv, err := createInventory("static/images", "static/videos", slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.App.inventory = inv
+	rec := serve(NewRouter(h, testLimiters(10)), "GET", "/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	m := regexp.MustCompile(`<a href="/r/([^"]*)">Geschichten aus der Flachsmaschine`).FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatal("link to stories missing")
+	}
+	if _, err := inv.ParseCompositeID(m[1]); err != nil {
+		t.Errorf("link to stories: %v", err)
+	}
+}
+
+func TestAttribution(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	inv := h.App.Inventory()
+	for i, img := range inv.Images {
+		if img.Category == "artifacts" && img.Identifier == "01" {
+			inv.Images[i].Metadata = &ImageMetadata{Title: "Spinnrad <alt>", License: "CC0"}
+		}
+	}
+	r := NewRouter(h, testLimiters(10))
+	serve(r, "POST", "/w/010203", url.Values{"story": {"Eine Geschichte."}, "language": {"ger"}})
+	for _, target := range []string{"/r/010203", "/s/1", "/w/010203"} {
+		rec := serve(r, "GET", target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", target, rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, `<p class="attribution">`) ||
+			!strings.Contains(body, "Spinnrad &lt;alt&gt;, CC0") {
+			t.Errorf("GET %s: attribution missing", target)
+		}
+	}
+}
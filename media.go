@@ -0,0 +1,138 @@
+package dvmweb
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// Register WebP decoding for image.Decode, imaging handles the rest.
+	_ "golang.org/x/image/webp"
+)
+
+// imageExtensions are the recognized image file extensions, lowercase.
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+	".tif":  true,
+	".tiff": true,
+}
+
+// videoTypes maps recognized video extensions to MIME types. The order of
+// videoFormats is the order of sources offered to browsers.
+var (
+	videoTypes = map[string]string{
+		".webm": "video/webm",
+		".mp4":  "video/mp4",
+		".ogv":  "video/ogg",
+	}
+	videoFormats = []string{".webm", ".mp4", ".ogv"}
+)
+
+// isImage returns true, if the filename has a recognized image extension.
+func isImage(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// VideoSource is a video file in a single format.
+type VideoSource struct {
+	Name string `json:"name"` // Basename, e.g. dvm-010203.webm.
+	Path string `json:"-"`
+	Type string `json:"type"` // MIME type.
+}
+
+// Video is a clip, available in one or more formats. Videos named after a
+// combination, e.g. dvm-010203, show the images of that combination.
+type Video struct {
+	Identifier  string        `json:"id"`
+	Combination string        `json:"combination,omitempty"` // Empty, if the name is no valid combination.
+	Sources     []VideoSource `json:"sources"`
+}
+
+// linkVideos sets the combination of each video, whose identifier parses
+// as a composite identifier.
+func (inv *Inventory) linkVideos() {
+	for i, v := range inv.Videos {
+		cid, err := inv.ParseCompositeID(v.Identifier)
+		if err != nil {
+			log.Printf("video %s does not match a combination: %v", v.Identifier, err)
+			continue
+		}
+		inv.Videos[i].Combination = cid.String()
+	}
+}
+
+// VideoByIdentifier returns a video or nil.
+func (inv *Inventory) VideoByIdentifier(vid string) *Video {
+	for _, v := range inv.Videos {
+		if v.Identifier == vid {
+			return &v
+		}
+	}
+	return nil
+}
+
+// VideoForCombination returns the video showing a combination or nil.
+func (inv *Inventory) VideoForCombination(iid string) *Video {
+	for _, v := range inv.Videos {
+		if v.Combination != "" && v.Combination == iid {
+			return &v
+		}
+	}
+	return nil
+}
+
+// sourceRank orders sources like videoFormats.
+func sourceRank(name string) int {
+	ext := strings.ToLower(filepath.Ext(name))
+	for i, f := range videoFormats {
+		if f == ext {
+			return i
+		}
+	}
+	return len(videoFormats)
+}
+
+// findVideos groups video files by identifier, e.g. dvm-010203.mp4 and
+// dvm-010203.webm become one video with two sources.
+func findVideos(dir string) (videos []Video, err error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Video)
+	var ids []string
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		typ, ok := videoTypes[strings.ToLower(filepath.Ext(fi.Name()))]
+		if !ok {
+			continue
+		}
+		id := videoIdentifier(fi.Name())
+		v, ok := byID[id]
+		if !ok {
+			v = &Video{Identifier: id}
+			byID[id] = v
+			ids = append(ids, id)
+		}
+		v.Sources = append(v.Sources, VideoSource{
+			Name: fi.Name(),
+			Path: filepath.Join(dir, fi.Name()),
+			Type: typ,
+		})
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		v := byID[id]
+		sort.Slice(v.Sources, func(i, j int) bool {
+			return sourceRank(v.Sources[i].Name) < sourceRank(v.Sources[j].Name)
+		})
+		videos = append(videos, *v)
+	}
+	return videos, nil
+}
@@ -0,0 +1,143 @@
+package dvmweb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Metadata sidecar files, looked up in each category directory. JSON is
+// preferred, if both exist.
+const (
+	metadataJSON = "metadata.json"
+	metadataCSV  = "metadata.csv"
+)
+
+// ImageMetadata describes provenance and license of a single image. All
+// fields are optional.
+type ImageMetadata struct {
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Place        string `json:"place,omitempty"`
+	Date         string `json:"date,omitempty"`
+	Photographer string `json:"photographer,omitempty"`
+	SourceURL    string `json:"source_url,omitempty"`
+	License      string `json:"license,omitempty"`
+}
+
+// metadataRecord is an entry in a sidecar file.
+type metadataRecord struct {
+	Identifier string `json:"id"`
+	ImageMetadata
+}
+
+// set assigns a value by column name, unknown columns are ignored.
+func (m *ImageMetadata) set(column, value string) {
+	value = strings.TrimSpace(value)
+	switch column {
+	case "title":
+		m.Title = value
+	case "description":
+		m.Description = value
+	case "place":
+		m.Place = value
+	case "date":
+		m.Date = value
+	case "photographer":
+		m.Photographer = value
+	case "source_url":
+		m.SourceURL = value
+	case "license":
+		m.License = value
+	}
+}
+
+// readMetadataCSV reads a CSV file with a header row. An id column is
+// required, it names the image, e.g. 01 for 01.jpg.
+func readMetadataCSV(r io.Reader) (map[string]ImageMetadata, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idColumn = -1
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if header[i] == "id" {
+			idColumn = i
+		}
+	}
+	if idColumn == -1 {
+		return nil, fmt.Errorf("missing id column")
+	}
+	result := make(map[string]ImageMetadata)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if idColumn >= len(row) {
+			continue
+		}
+		var m ImageMetadata
+		for i, value := range row {
+			if i < len(header) {
+				m.set(header[i], value)
+			}
+		}
+		result[strings.TrimSpace(row[idColumn])] = m
+	}
+	return result, nil
+}
+
+// readMetadataJSON reads a JSON array of objects with id and metadata fields.
+func readMetadataJSON(r io.Reader) (map[string]ImageMetadata, error) {
+	var records []metadataRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, err
+	}
+	result := make(map[string]ImageMetadata)
+	for _, rec := range records {
+		result[rec.Identifier] = rec.ImageMetadata
+	}
+	return result, nil
+}
+
+// loadMetadata reads the optional sidecar file of a category directory. It
+// returns nil, if there is none.
+func loadMetadata(dir string) (map[string]ImageMetadata, error) {
+	for _, sidecar := range []struct {
+		name string
+		read func(io.Reader) (map[string]ImageMetadata, error)
+	}{
+		{metadataJSON, readMetadataJSON},
+		{metadataCSV, readMetadataCSV},
+	} {
+		filename := filepath.Join(dir, sidecar.name)
+		f, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		m, err := sidecar.read(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return m, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,253 @@
+package dvmweb
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a single, numbered schema change. Migrations are applied in
+// order and each version is recorded in the schema_migrations table. SQL
+// contains the statements per driver name, since DDL differs between
+// databases.
+type Migration struct {
+	Version     int
+	Description string
+	SQL         map[string]string
+}
+
+// migrations lists all schema changes known to this binary. Only ever append
+// to this list, never change an already released migration.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create story table",
+		SQL: map[string]string{
+			"sqlite3": `
+			CREATE TABLE IF NOT EXISTS story (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				imageid TEXT NOT NULL,
+				text TEXT NOT NULL,
+				language TEXT NOT NULL,
+				ip TEXT NOT NULL,
+				flagged INTEGER NOT NULL,
+				created DATE DEFAULT (datetime('now'))
+			)`,
+			"postgres": `
+			CREATE TABLE IF NOT EXISTS story (
+				id SERIAL PRIMARY KEY,
+				imageid TEXT NOT NULL,
+				text TEXT NOT NULL,
+				language TEXT NOT NULL,
+				ip TEXT NOT NULL,
+				flagged INTEGER NOT NULL,
+				created TIMESTAMP DEFAULT now()
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "add report and moderation log tables",
+		SQL: map[string]string{
+			"sqlite3": `
+			CREATE TABLE report (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				storyid INTEGER NOT NULL,
+				ip TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				created DATE DEFAULT (datetime('now'))
+			);
+			CREATE INDEX report_storyid ON report (storyid);
+			CREATE TABLE moderation_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				storyid INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				curator TEXT NOT NULL,
+				created DATE DEFAULT (datetime('now'))
+			)`,
+			"postgres": `
+			CREATE TABLE report (
+				id SERIAL PRIMARY KEY,
+				storyid INTEGER NOT NULL,
+				ip TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				created TIMESTAMP DEFAULT now()
+			);
+			CREATE INDEX report_storyid ON report (storyid);
+			CREATE TABLE moderation_log (
+				id SERIAL PRIMARY KEY,
+				storyid INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				curator TEXT NOT NULL,
+				created TIMESTAMP DEFAULT now()
+			)`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add curator and session tables",
+		SQL: map[string]string{
+			"sqlite3": `
+			CREATE TABLE curator (
+				name TEXT PRIMARY KEY,
+				password TEXT NOT NULL,
+				created DATE DEFAULT (datetime('now'))
+			);
+			CREATE TABLE session (
+				token TEXT PRIMARY KEY,
+				curator TEXT NOT NULL,
+				expires DATE NOT NULL
+			)`,
+			"postgres": `
+			CREATE TABLE curator (
+				name TEXT PRIMARY KEY,
+				password TEXT NOT NULL,
+				created TIMESTAMP DEFAULT now()
+			);
+			CREATE TABLE session (
+				token TEXT PRIMARY KEY,
+				curator TEXT NOT NULL,
+				expires TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "add spam label to story",
+		SQL: map[string]string{
+			"sqlite3":  `ALTER TABLE story ADD COLUMN label TEXT NOT NULL DEFAULT ''`,
+			"postgres": `ALTER TABLE story ADD COLUMN label TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// Existing rows are not checked, since we cannot know what to do
+		// with them here; new and updated rows need an alphanumeric id.
+		Version:     5,
+		Description: "require well formed image ids",
+		SQL: map[string]string{
+			"sqlite3": `
+			CREATE TRIGGER story_imageid_insert BEFORE INSERT ON story
+			WHEN NEW.imageid IS NULL OR NEW.imageid = '' OR NEW.imageid GLOB '*[^0-9A-Za-z]*'
+			BEGIN
+				SELECT RAISE(ABORT, 'invalid image id');
+			END;
+			CREATE TRIGGER story_imageid_update BEFORE UPDATE OF imageid ON story
+			WHEN NEW.imageid IS NULL OR NEW.imageid = '' OR NEW.imageid GLOB '*[^0-9A-Za-z]*'
+			BEGIN
+				SELECT RAISE(ABORT, 'invalid image id');
+			END`,
+			"postgres": `
+			ALTER TABLE story ADD CONSTRAINT story_imageid_check
+			CHECK (imageid ~ '^[0-9A-Za-z]+$') NOT VALID`,
+		},
+	},
+}
+
+// SchemaTooNewError is returned, if the database has been migrated by a newer
+// version of this program.
+type SchemaTooNewError struct {
+	Current int // Version found in database.
+	Known   int // Latest version known to this binary.
+}
+
+func (e SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than supported version %d", e.Current, e.Known)
+}
+
+// LatestSchemaVersion returns the version of the last known migration.
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ensureMigrationsTable creates the bookkeeping table, if necessary.
+func ensureMigrationsTable(db *sqlx.DB) error {
+	applied := `DATE DEFAULT (datetime('now'))`
+	if db.DriverName() == "postgres" {
+		applied = `TIMESTAMP DEFAULT now()`
+	}
+	_, err := db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied %s
+	)`, applied))
+	return err
+}
+
+// SchemaVersion returns the currently applied schema version, zero for an
+// empty database.
+func SchemaVersion(db *sqlx.DB) (version int, err error) {
+	if err = ensureMigrationsTable(db); err != nil {
+		return 0, err
+	}
+	err = db.Get(&version, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	return
+}
+
+// Migrate applies all pending migrations, each in its own transaction. It
+// refuses to touch a database with a schema newer than this binary knows.
+func Migrate(db *sqlx.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return SchemaTooNewError{Current: current, Known: LatestSchemaVersion()}
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		stmt, ok := m.SQL[db.DriverName()]
+		if !ok {
+			return fmt.Errorf("migration %d has no statements for driver %s", m.Version, db.DriverName())
+		}
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		if _, err := tx.Exec(tx.Rebind(`INSERT INTO schema_migrations (version, description) VALUES (?, ?)`),
+			m.Version, m.Description); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied migration %d: %s", m.Version, m.Description)
+	}
+	return nil
+}
+
+// DriverName selects a database driver for a data source name. URLs starting
+// with postgres:// or postgresql:// go to PostgreSQL, everything else is
+// considered a path to a sqlite3 database.
+func DriverName(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "postgres"
+	}
+	return "sqlite3"
+}
+
+// OpenDatabase opens a database and brings its schema up to date. The
+// drivers need to be registered by the caller.
+func OpenDatabase(dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open(DriverName(dsn), dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
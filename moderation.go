@@ -0,0 +1,70 @@
+package dvmweb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Moderation states of a story, kept in the flagged column of the story table.
+const (
+	StatusVisible  = 0 // Default, visible to everyone.
+	StatusFlagged  = 1 // Reported or auto-flagged, hidden until reviewed.
+	StatusHidden   = 2 // Hidden by a curator.
+	StatusApproved = 3 // Approved by a curator, reports will not hide it again.
+)
+
+// Moderation actions a curator can take.
+const (
+	ActionApprove = "approve"
+	ActionHide    = "hide"
+	ActionDelete  = "delete"
+	ActionSpam    = "spam" // Hide and label as spam for the classifier.
+	ActionHam     = "ham"  // Approve and label as ham for the classifier.
+	ActionEdit    = "edit" // Only recorded in the log, not a queue action.
+)
+
+// statusByAction maps non-destructive actions to the resulting status.
+var statusByAction = map[string]int{
+	ActionApprove: StatusApproved,
+	ActionHide:    StatusHidden,
+	ActionSpam:    StatusHidden,
+	ActionHam:     StatusApproved,
+}
+
+// labelByAction maps actions to the training label they attach.
+var labelByAction = map[string]string{
+	ActionSpam: LabelSpam,
+	ActionHam:  LabelHam,
+}
+
+// checkAction returns an error for unknown moderation actions.
+func checkAction(action string) error {
+	if _, ok := statusByAction[action]; ok || action == ActionDelete {
+		return nil
+	}
+	return fmt.Errorf("unknown moderation action: %s", action)
+}
+
+// Report is a complaint about a story by a visitor.
+type Report struct {
+	Identifier      int       `db:"id"`
+	StoryIdentifier int       `db:"storyid"`
+	IP              string    `db:"ip"`
+	Reason          string    `db:"reason"`
+	Created         time.Time `db:"created"`
+}
+
+// QueueItem is a flagged story waiting for review, along with its reports.
+type QueueItem struct {
+	Story   Story
+	Reports []Report
+}
+
+// ModerationEntry records a curator decision in the audit trail.
+type ModerationEntry struct {
+	Identifier      int       `db:"id"`
+	StoryIdentifier int       `db:"storyid"`
+	Action          string    `db:"action"`
+	Curator         string    `db:"curator"`
+	Created         time.Time `db:"created"`
+}
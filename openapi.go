@@ -0,0 +1,276 @@
+package dvmweb
+
+import "net/http"
+
+// openAPISpec describes the JSON API in OpenAPI 3 format. Keep it in sync
+// with the routes registered under /api/v1, TestAPIRoutes will complain
+// otherwise.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Die virtuelle Mittagsfrau",
+    "description": "Stories written for random combinations of historical images.",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "paths": {
+    "/stories": {
+      "get": {
+        "summary": "List recent stories, newest first.",
+        "parameters": [
+          {"$ref": "#/components/parameters/page"},
+          {"$ref": "#/components/parameters/per_page"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/StoryPage"},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create a new story.",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["image", "text"],
+                "properties": {
+                  "image": {"type": "string", "example": "010203"},
+                  "text": {"type": "string", "maxLength": 10000},
+                  "language": {"type": "string", "enum": ["ger", "hsb", "dsb", "eng"], "default": "ger"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "The saved story.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Story"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "429": {
+            "description": "Too many requests, see Retry-After header.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+          }
+        }
+      }
+    },
+    "/stories/{id}": {
+      "get": {
+        "summary": "Get a single story.",
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}, "example": 1}
+        ],
+        "responses": {
+          "200": {
+            "description": "A story.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Story"}}}
+          },
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/images/{iid}/stories": {
+      "get": {
+        "summary": "List the stories for an image combination.",
+        "parameters": [
+          {"name": "iid", "in": "path", "required": true, "schema": {"type": "string"}, "example": "010203"},
+          {"$ref": "#/components/parameters/page"},
+          {"$ref": "#/components/parameters/per_page"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/StoryPage"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/random": {
+      "get": {
+        "summary": "Get a random image combination.",
+        "parameters": [
+          {"name": "strategy", "in": "query", "schema": {"type": "string", "enum": ["uniform", "prefer-unwritten", "prefer-popular", "least-recently-shown"]}, "example": "prefer-unwritten"}
+        ],
+        "responses": {
+          "200": {
+            "description": "A combination.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Combination"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/videos": {
+      "get": {
+        "summary": "List videos and the combinations they show.",
+        "responses": {
+          "200": {
+            "description": "All videos.",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Video"}}}}
+          }
+        }
+      }
+    },
+    "/categories": {
+      "get": {
+        "summary": "List image categories and their images.",
+        "responses": {
+          "200": {
+            "description": "Categories.",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/Category"}}
+              }
+            }
+          }
+        }
+      }
+    },
+    "/search": {
+      "get": {
+        "summary": "Search stories.",
+        "parameters": [
+          {"name": "q", "in": "query", "required": true, "schema": {"type": "string"}, "example": "flachs"},
+          {"name": "lang", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Matching stories with highlighted snippets.",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/SearchResult"}}
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "page": {"name": "page", "in": "query", "schema": {"type": "integer", "minimum": 1, "default": 1}},
+      "per_page": {"name": "per_page", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}}
+    },
+    "responses": {
+      "Error": {
+        "description": "An error.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "StoryPage": {
+        "description": "A page of stories.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StoryPage"}}}
+      }
+    },
+    "schemas": {
+      "Story": {
+        "type": "object",
+        "required": ["id", "image", "text", "language", "created"],
+        "properties": {
+          "id": {"type": "integer"},
+          "image": {"type": "string"},
+          "text": {"type": "string"},
+          "language": {"type": "string"},
+          "created": {"type": "string", "format": "date-time"}
+        }
+      },
+      "StoryPage": {
+        "type": "object",
+        "required": ["page", "per_page", "items"],
+        "properties": {
+          "page": {"type": "integer"},
+          "per_page": {"type": "integer"},
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/Story"}},
+          "next": {"type": "string"}
+        }
+      },
+      "Image": {
+        "type": "object",
+        "required": ["id", "category"],
+        "properties": {
+          "id": {"type": "string"},
+          "category": {"type": "string"},
+          "metadata": {"$ref": "#/components/schemas/ImageMetadata"}
+        }
+      },
+      "ImageMetadata": {
+        "type": "object",
+        "properties": {
+          "title": {"type": "string"},
+          "description": {"type": "string"},
+          "place": {"type": "string"},
+          "date": {"type": "string"},
+          "photographer": {"type": "string"},
+          "source_url": {"type": "string"},
+          "license": {"type": "string"}
+        }
+      },
+      "Combination": {
+        "type": "object",
+        "required": ["id", "image_url", "images"],
+        "properties": {
+          "id": {"type": "string"},
+          "image_url": {"type": "string"},
+          "images": {"type": "array", "items": {"$ref": "#/components/schemas/Image"}},
+          "video": {"$ref": "#/components/schemas/Video"}
+        }
+      },
+      "Video": {
+        "type": "object",
+        "required": ["id", "sources"],
+        "properties": {
+          "id": {"type": "string"},
+          "combination": {"type": "string", "description": "Combination shown, if the video is named after one."},
+          "sources": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["name", "type"],
+              "properties": {
+                "name": {"type": "string"},
+                "type": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Category": {
+        "type": "object",
+        "required": ["name", "images"],
+        "properties": {
+          "name": {"type": "string"},
+          "images": {"type": "array", "items": {"$ref": "#/components/schemas/Image"}}
+        }
+      },
+      "SearchResult": {
+        "type": "object",
+        "required": ["story", "snippet"],
+        "properties": {
+          "story": {"$ref": "#/components/schemas/Story"},
+          "snippet": {"type": "string"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
+
+// apiPrefix is the path prefix of all documented API routes.
+const apiPrefix = "/api/v1"
+
+// OpenAPIHandler serves the API specification.
+func (h *Handler) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if _, err := w.Write([]byte(openAPISpec)); err != nil {
+		writeHeaderLogf(w, http.StatusInternalServerError, "cannot write spec: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package dvmweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIRoutes(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	if err := checkAPIRoutes(NewRouter(h, testLimiters(10))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIResponses(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	// The examples in the spec refer to this story.
+	if _, err := h.App.Store.InsertStory(Story{
+		ImageIdentifier: "010203",
+		Text:            "Ein Feld voller Flachs.",
+		Language:        "ger",
+		Status:          StatusVisible,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range checkAPIResponses(NewRouter(h, testLimiters(10))) {
+		t.Error(err)
+	}
+}
+
+// schema is the subset of JSON schema used in the spec.
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+}
+
+// parameter of an operation.
+type parameter struct {
+	Ref     string      `json:"$ref"`
+	Name    string      `json:"name"`
+	In      string      `json:"in"`
+	Example interface{} `json:"example"`
+}
+
+// response of an operation.
+type response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+// operation is a single method on a path.
+type operation struct {
+	Parameters []parameter          `json:"parameters"`
+	Responses  map[string]*response `json:"responses"`
+}
+
+// spec is the subset of an OpenAPI document, that we check.
+type spec struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas    map[string]*schema   `json:"schemas"`
+		Responses  map[string]*response `json:"responses"`
+		Parameters map[string]parameter `json:"parameters"`
+	} `json:"components"`
+}
+
+// parseSpec parses the embedded API specification.
+func parseSpec() (*spec, error) {
+	var s spec
+	if err := json.Unmarshal([]byte(openAPISpec), &s); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %v", err)
+	}
+	return &s, nil
+}
+
+// refName returns the last component of a local reference.
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// routePattern removes regular expressions from mux path variables, e.g.
+// {id:[0-9]+} becomes {id}.
+var routePattern = regexp.MustCompile(`{([^:}]+):[^}]+}`)
+
+// apiRoutes walks a router and returns "METHOD /path" for all API routes,
+// with paths relative to the API prefix.
+func apiRoutes(router *mux.Router) (routes []string, err error) {
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tpl, apiPrefix+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := routePattern.ReplaceAllString(strings.TrimPrefix(tpl, apiPrefix), "{$1}")
+		for _, m := range methods {
+			routes = append(routes, strings.ToUpper(m)+" "+path)
+		}
+		return nil
+	})
+	sort.Strings(routes)
+	return routes, err
+}
+
+// checkAPIRoutes returns an error, if the API routes registered on a router
+// and the routes documented in the spec differ.
+func checkAPIRoutes(router *mux.Router) error {
+	s, err := parseSpec()
+	if err != nil {
+		return err
+	}
+	routes, err := apiRoutes(router)
+	if err != nil {
+		return err
+	}
+	registered := make(map[string]bool)
+	for _, r := range routes {
+		registered[r] = true
+	}
+	var problems []string
+	for path, ops := range s.Paths {
+		for method := range ops {
+			key := strings.ToUpper(method) + " " + path
+			if !registered[key] {
+				problems = append(problems, "documented, but not registered: "+key)
+			}
+			delete(registered, key)
+		}
+	}
+	for key := range registered {
+		problems = append(problems, "registered, but not documented: "+key)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("api routes and spec differ: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validate checks a decoded JSON value against a schema.
+func (s *spec) validate(sc *schema, v interface{}, path string) error {
+	if sc.Ref != "" {
+		ref, ok := s.Components.Schemas[refName(sc.Ref)]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, sc.Ref)
+		}
+		return s.validate(ref, v, path)
+	}
+	switch sc.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, v)
+		}
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+		for name, prop := range sc.Properties {
+			if value, ok := obj[name]; ok {
+				if err := s.validate(prop, value, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, v)
+		}
+		for i, item := range arr {
+			if err := s.validate(sc.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, v)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return fmt.Errorf("%s: expected integer, got %v", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %T", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", path, v)
+		}
+	}
+	return nil
+}
+
+// requestPath fills in path parameters with their examples and adds
+// required query parameters.
+func (s *spec) requestPath(path string, op *operation) string {
+	var query []string
+	for _, p := range op.Parameters {
+		if p.Ref != "" {
+			p = s.Components.Parameters[refName(p.Ref)]
+		}
+		if p.Example == nil {
+			continue
+		}
+		value := fmt.Sprintf("%v", p.Example)
+		if f, ok := p.Example.(float64); ok {
+			value = strconv.FormatInt(int64(f), 10)
+		}
+		switch p.In {
+		case "path":
+			path = strings.Replace(path, "{"+p.Name+"}", value, -1)
+		case "query":
+			query = append(query, p.Name+"="+value)
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + strings.Join(query, "&")
+	}
+	return apiPrefix + path
+}
+
+// checkAPIResponses requests every documented GET operation from a handler,
+// using parameter examples, and validates the response body against the
+// schema declared for the returned status code. Operations with side effects
+// are not requested. All examples must refer to existing data, every status
+// but 200 is an error.
+func checkAPIResponses(handler http.Handler) (errs []error) {
+	s, err := parseSpec()
+	if err != nil {
+		return []error{err}
+	}
+	for path, ops := range s.Paths {
+		op, ok := ops["get"]
+		if !ok {
+			continue
+		}
+		target := s.requestPath(path, op)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		resp, ok := op.Responses[strconv.Itoa(rec.Code)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("GET %s: undocumented status %d", target, rec.Code))
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			errs = append(errs, fmt.Errorf("GET %s: status %d: %s", target, rec.Code, rec.Body.String()))
+		}
+		if resp.Ref != "" {
+			resp = s.Components.Responses[refName(resp.Ref)]
+		}
+		content, ok := resp.Content["application/json"]
+		if !ok {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			errs = append(errs, fmt.Errorf("GET %s: content type %q, want application/json", target, ct))
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			errs = append(errs, fmt.Errorf("GET %s: invalid JSON: %v", target, err))
+			continue
+		}
+		if err := s.validate(content.Schema, v, "$"); err != nil {
+			errs = append(errs, fmt.Errorf("GET %s: %v", target, err))
+		}
+	}
+	return errs
+}
@@ -3,21 +3,26 @@ package dvmweb
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"math/rand"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/jmoiron/sqlx"
 )
 
 // CategorizedImage belongs to a category, path records the absolute path. The
 // identifier is just the basename of the image, e.g. 12 for 12.jpg file.
 type CategorizedImage struct {
-	Identifier string
-	Path       string
-	Category   string
+	Identifier string         `json:"id"`
+	Path       string         `json:"-"`
+	Category   string         `json:"category"`
+	Metadata   *ImageMetadata `json:"metadata,omitempty"` // From an optional sidecar file.
+
+	modTime time.Time // To detect changed files on reload.
+	size    int64
 }
 
 // Inventory make images and videos accessible in various ways. The slices
@@ -25,8 +30,59 @@ type CategorizedImage struct {
 // exposes a few functions, that help to locate images by category, by
 // identifier and the like. TODO(miku): maybe categorize videos as well.
 type Inventory struct {
+	Slots  Slots
 	Images []CategorizedImage
-	Videos []string
+	Videos []Video
+
+	sel *selection // Created on first use, if nil.
+}
+
+// selection is the random source and display history of an inventory. It is
+// handed over to the new inventory on reload.
+type selection struct {
+	mu    sync.Mutex // The random source is not safe for concurrent use.
+	rnd   *rand.Rand
+	shown map[string]time.Time // When a combination has been shown last.
+}
+
+// newSelection uses a random source seeded with the current time.
+func newSelection() *selection {
+	return &selection{
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		shown: make(map[string]time.Time),
+	}
+}
+
+// selMu guards the creation of selections on first use.
+var selMu sync.Mutex
+
+// selection returns random source and display history, which are created on
+// first use, so an inventory literal is usable as well.
+func (inv *Inventory) selection() *selection {
+	selMu.Lock()
+	defer selMu.Unlock()
+	if inv.sel == nil {
+		inv.sel = newSelection()
+	}
+	return inv.sel
+}
+
+// SetRandSource sets the source for all random choices. With a fixed seed, the
+// sequence of combinations is reproducible. By default, a source seeded with
+// the current time is used.
+func (inv *Inventory) SetRandSource(src rand.Source) {
+	sel := inv.selection()
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	sel.rnd = rand.New(src)
+}
+
+// intn returns a random number in [0, n).
+func (inv *Inventory) intn(n int) int {
+	sel := inv.selection()
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	return sel.rnd.Intn(n)
 }
 
 // videoIdentifier returns the identifier of a video, given its path. Go from
@@ -52,21 +108,28 @@ func (inv *Inventory) ByCategoryAndIdentifier(category, identifier string) (*Cat
 	return nil, fmt.Errorf("image not found: %s/%s", category, identifier)
 }
 
-// RandomImageIdentifier returns a random composite image identifier from
-// fixed categories (artifacts, people, landscape).
-func (inv *Inventory) RandomImageIdentifier() (rid string, err error) {
-	artifacts := inv.ByCategory("artifacts")
-	people := inv.ByCategory("people")
-	landscapes := inv.ByCategory("landscapes")
-
-	if len(artifacts) == 0 || len(people) == 0 || len(landscapes) == 0 {
-		return "", fmt.Errorf("incomplete image dirs, missing at one category")
+// slotImages returns the images, which fit into a slot.
+func (inv *Inventory) slotImages(slot Slot) (images []CategorizedImage) {
+	for _, img := range inv.ByCategory(slot.Category) {
+		if len(img.Identifier) == slot.Width {
+			images = append(images, img)
+		}
 	}
+	return
+}
 
-	return fmt.Sprintf("%s%s%s",
-		artifacts[rand.Intn(len(artifacts))].Identifier,
-		artifacts[rand.Intn(len(people))].Identifier,
-		artifacts[rand.Intn(len(landscapes))].Identifier), nil
+// RandomImageIdentifier returns a random composite image identifier with one
+// image per slot.
+func (inv *Inventory) RandomImageIdentifier() (rid string, err error) {
+	var ids []string
+	for _, slot := range inv.Slots {
+		images := inv.slotImages(slot)
+		if len(images) == 0 {
+			return "", fmt.Errorf("incomplete image dirs, no images for %s", slot.Category)
+		}
+		ids = append(ids, images[inv.intn(len(images))].Identifier)
+	}
+	return strings.Join(ids, ""), nil
 }
 
 // ByCategory returns image paths by category, empty list, if the category does not exist.
@@ -79,12 +142,22 @@ func (inv *Inventory) ByCategory(category string) (images []CategorizedImage) {
 	return
 }
 
+// RandomVideo returns a random video.
+func (inv *Inventory) RandomVideo() (*Video, error) {
+	if len(inv.Videos) == 0 {
+		return nil, fmt.Errorf("no videos found")
+	}
+	v := inv.Videos[inv.intn(len(inv.Videos))]
+	return &v, nil
+}
+
 // RandomVideoIdentifier returns the id to a random video.
 func (inv *Inventory) RandomVideoIdentifier() (vid string, err error) {
-	if len(inv.Videos) == 0 {
-		return "", fmt.Errorf("no videos found")
+	v, err := inv.RandomVideo()
+	if err != nil {
+		return "", err
 	}
-	return videoIdentifier(inv.Videos[rand.Intn(len(inv.Videos))]), nil
+	return v.Identifier, nil
 }
 
 // Categories returns the unique image categories.
@@ -99,26 +172,54 @@ func (inv *Inventory) Categories() (categories []string) {
 	return
 }
 
-// Ok checks if the inventory is somewhat usable. Also, we want three categories at the moment.
+// Ok checks if the inventory is somewhat usable, that is, there are images
+// for every slot.
 func (inv *Inventory) Ok() bool {
-	return len(inv.Images) > 10 && len(inv.Videos) > 0 && len(inv.Categories()) == 3
+	if len(inv.Images) <= 10 || len(inv.Videos) == 0 || len(inv.Slots) == 0 {
+		return false
+	}
+	for _, slot := range inv.Slots {
+		if len(inv.slotImages(slot)) == 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // Story describes a minimal story.
 type Story struct {
-	Identifier      int       `db:"id"`
-	ImageIdentifier string    `db:"imageid"`
-	Text            string    `db:"text"`
-	Language        string    `db:"language"`
-	Created         time.Time `db:"created"`
+	Identifier      int       `db:"id" json:"id"`
+	ImageIdentifier string    `db:"imageid" json:"image"`
+	Text            string    `db:"text" json:"text"`
+	Language        string    `db:"language" json:"language"`
+	IP              string    `db:"ip" json:"-"`
+	Status          int       `db:"flagged" json:"-"`
+	Label           string    `db:"label" json:"-"`
+	Created         time.Time `db:"created" json:"created"`
+}
+
+// Public returns true, if the story may be shown to visitors.
+func (s Story) Public() bool {
+	return s.Status == StatusVisible || s.Status == StatusApproved
 }
 
 // App configuration and data access layer.
 type App struct {
-	db        *sqlx.DB
+	Store     Store
 	videosDir string
 	imagesDir string
-	Inventory *Inventory
+
+	mu        sync.RWMutex // Protects inventory, which is replaced on reload.
+	inventory *Inventory
+	reloadMu  sync.Mutex // Serializes reloads.
+}
+
+// Inventory returns the current inventory. It is replaced as a whole on
+// reload, so callers should use a single inventory per request.
+func (app *App) Inventory() *Inventory {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.inventory
 }
 
 // subdirNames returns the names of direct subfolders.
@@ -148,7 +249,7 @@ func findImages(root, category string) (filenames []string, err error) {
 		if fi.IsDir() {
 			continue
 		}
-		if !strings.HasSuffix(fi.Name(), ".jpg") {
+		if !isImage(fi.Name()) {
 			continue
 		}
 		filenames = append(filenames, filepath.Join(cdir, fi.Name()))
@@ -157,66 +258,84 @@ func findImages(root, category string) (filenames []string, err error) {
 }
 
 // createInventory fixes images and video paths in an inventory.
-func createInventory(imagesDir, videosDir string) (*Inventory, error) {
+func createInventory(imagesDir, videosDir string, slots Slots) (*Inventory, error) {
 	subdirs, err := subdirNames(imagesDir)
 	if err != nil {
 		return nil, err
 	}
-	inv := Inventory{}
+	inv := Inventory{Slots: slots}
 
-	// Read and categorize images.
+	// Read and categorize images. The same identifier in different formats
+	// is ambiguous, only the first one is used.
 	for _, c := range subdirs {
 		files, err := findImages(imagesDir, c)
 		if err != nil {
 			return nil, err
 		}
+		metadata, err := loadMetadata(filepath.Join(imagesDir, c))
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
 		for _, p := range files {
-			inv.Images = append(inv.Images, CategorizedImage{
+			img := CategorizedImage{
 				Identifier: strings.Replace(path.Base(p), path.Ext(p), "", -1),
 				Path:       p,
 				Category:   c,
-			})
+			}
+			if seen[img.Identifier] {
+				log.Printf("ignoring %s, identifier %s/%s already taken", p, c, img.Identifier)
+				continue
+			}
+			seen[img.Identifier] = true
+			if fi, err := os.Stat(p); err == nil {
+				img.modTime, img.size = fi.ModTime(), fi.Size()
+			}
+			if m, ok := metadata[img.Identifier]; ok {
+				img.Metadata = &m
+			}
+			inv.Images = append(inv.Images, img)
 		}
 	}
 
-	fis, err := ioutil.ReadDir(videosDir)
-	if err != nil {
+	if inv.Videos, err = findVideos(videosDir); err != nil {
 		return nil, err
 	}
-	for _, fi := range fis {
-		// XXX: allow for various formats.
-		if !strings.HasSuffix(fi.Name(), ".mp4") {
-			continue
-		}
-		inv.Videos = append(inv.Videos, filepath.Join(videosDir, fi.Name()))
-	}
+	inv.linkVideos()
 	return &inv, nil
 }
 
-// New create a new web app given a data source and some static directories.
-func New(dsn, imagesDir, videosDir string) (*App, error) {
-	inv, err := createInventory(imagesDir, videosDir)
+// New create a new web app given a data source, some static directories and
+// the slot layout of composite images. Pending database migrations are
+// applied.
+func New(dsn, imagesDir, videosDir string, slots Slots) (*App, error) {
+	inv, err := createInventory(imagesDir, videosDir, slots)
 	if err != nil {
 		return nil, err
 	}
 	if !inv.Ok() {
-		return nil, fmt.Errorf("incomplete inventory")
+		return nil, fmt.Errorf("incomplete inventory for slots %s", slots)
 	}
-	db, err := sqlx.Open("sqlite3", dsn)
+	db, err := OpenDatabase(dsn)
 	if err != nil {
 		return nil, err
 	}
+	store := NewSQLStore(db)
+	if err := store.SetupFullText(); err != nil {
+		return nil, err
+	}
 	return &App{
-		db:        db,
+		Store:     &countingStore{Store: store},
 		videosDir: videosDir,
 		imagesDir: imagesDir,
-		Inventory: inv,
+		inventory: inv,
 	}, nil
 }
 
 func (app *App) String() string {
+	inv := app.Inventory()
 	return fmt.Sprintf("app with %d images in %d categories and %d videos",
-		len(app.Inventory.Images),
-		len(app.Inventory.Categories()),
-		len(app.Inventory.Videos))
+		len(inv.Images),
+		len(inv.Categories()),
+		len(inv.Videos))
 }
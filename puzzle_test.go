@@ -0,0 +1,72 @@
+package dvmweb
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// disjointInventory has ten images per category of DefaultSlots, with
+// identifiers no other category uses: artifacts 00-09, people 10-19 and
+// landscapes 20-29. An identifier taken from the wrong category does not
+// resolve.
+func disjointInventory() *Inventory {
+	inv := &Inventory{Slots: DefaultSlots}
+	for i, slot := range DefaultSlots {
+		for j := 0; j < 10; j++ {
+			inv.Images = append(inv.Images, CategorizedImage{
+				Identifier: fmt.Sprintf("%d%d", i, j),
+				Category:   slot.Category,
+			})
+		}
+	}
+	return inv
+}
+
+// randomIdentifiers draws n combinations with a strategy from a seeded
+// inventory.
+func randomIdentifiers(t *testing.T, inv *Inventory, seed int64, s Strategy, counts map[string]int, n int) []string {
+	t.Helper()
+	inv.SetRandSource(rand.NewSource(seed))
+	var iids []string
+	for i := 0; i < n; i++ {
+		iid, err := inv.RandomImageIdentifierWith(s, counts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iids = append(iids, iid)
+	}
+	return iids
+}
+
+func TestRandomIdentifiersReproducible(t *testing.T) {
+	counts := map[string]int{"001020": 2}
+	for _, s := range Strategies {
+		a := randomIdentifiers(t, disjointInventory(), 42, s, counts, 100)
+		b := randomIdentifiers(t, disjointInventory(), 42, s, counts, 100)
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%s: same seed, different sequences: %v, %v", s, a[:5], b[:5])
+		}
+	}
+}
+
+func TestRandomIdentifiersResolve(t *testing.T) {
+	// Includes a combination with identifiers in the wrong slots, which
+	// preferPopular must skip.
+	counts := map[string]int{"001020": 2, "201000": 5}
+	for _, s := range Strategies {
+		inv := disjointInventory()
+		for _, iid := range randomIdentifiers(t, inv, 1, s, counts, 2000) {
+			ids, err := inv.Slots.Split(iid)
+			if err != nil {
+				t.Fatalf("%s: generated %s: %v", s, iid, err)
+			}
+			for i, slot := range inv.Slots {
+				if _, err := inv.ByCategoryAndIdentifier(slot.Category, ids[i]); err != nil {
+					t.Fatalf("%s: generated %s, %s not in %s: %v", s, iid, ids[i], slot.Category, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package dvmweb
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit configures a token bucket: Rate tokens are added per second, up to
+// Burst tokens. Each request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit like "0.5:3" (rate per second, burst).
+func ParseLimit(s string) (Limit, error) {
+	var l Limit
+	if _, err := fmt.Sscanf(s, "%g:%d", &l.Rate, &l.Burst); err != nil {
+		return l, fmt.Errorf("invalid limit %q, want rate:burst: %v", s, err)
+	}
+	if l.Rate <= 0 || l.Burst < 1 {
+		return l, fmt.Errorf("invalid limit %q, rate and burst must be positive", s)
+	}
+	return l, nil
+}
+
+// bucket is a token bucket for a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket per client key, e.g. an IP address.
+type RateLimiter struct {
+	Limit   Limit
+	Methods []string // Only limit these methods, all if empty.
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// NewRateLimiter creates a limiter for the given methods.
+func NewRateLimiter(limit Limit, methods ...string) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Methods: methods,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// applies returns true, if requests with the given method are limited.
+func (l *RateLimiter) applies(method string) bool {
+	if len(l.Methods) == 0 {
+		return true
+	}
+	for _, m := range l.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow takes a token for key. If none is left, it returns false and the
+// time until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.Limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// prune removes buckets, which would be full by now anyway. Callers must
+// hold the lock.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	full := time.Duration(float64(l.Limit.Burst) / l.Limit.Rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastPrune = now
+}
+
+// ParseNetworks parses a comma separated list of IP addresses or CIDR
+// networks, e.g. "127.0.0.1,10.0.0.0/8".
+func ParseNetworks(s string) (networks []*net.IPNet, err error) {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// containsIP reports, whether any network contains ip.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client. If the request comes from a
+// trusted proxy, X-Forwarded-For is consulted from right to left and the
+// first address not belonging to a trusted proxy is used.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(trusted, ip) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		host = hop.String()
+		if !containsIP(trusted, hop) {
+			break
+		}
+	}
+	return host
+}
@@ -0,0 +1,210 @@
+package dvmweb
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InventoryChanges lists what changed between two inventories. Images are
+// named category/identifier, videos by file name.
+type InventoryChanges struct {
+	Added         []string
+	Removed       []string
+	Modified      []string // File changed, composites need to be rebuilt.
+	Metadata      []string // Only the metadata changed.
+	VideosAdded   []string
+	VideosRemoved []string
+}
+
+// Empty returns true, if nothing changed.
+func (c InventoryChanges) Empty() bool {
+	return len(c.Added)+len(c.Removed)+len(c.Modified)+len(c.Metadata)+
+		len(c.VideosAdded)+len(c.VideosRemoved) == 0
+}
+
+func (c InventoryChanges) String() string {
+	var parts []string
+	for _, v := range []struct {
+		name  string
+		items []string
+	}{
+		{"added", c.Added},
+		{"removed", c.Removed},
+		{"modified", c.Modified},
+		{"metadata", c.Metadata},
+		{"videos added", c.VideosAdded},
+		{"videos removed", c.VideosRemoved},
+	} {
+		if len(v.items) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", v.name, strings.Join(v.items, " ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// imageKey names an image in change lists.
+func imageKey(category, identifier string) string {
+	return category + "/" + identifier
+}
+
+// diffInventory compares two inventories.
+func diffInventory(old, new *Inventory) (c InventoryChanges) {
+	before := make(map[string]CategorizedImage)
+	for _, img := range old.Images {
+		before[imageKey(img.Category, img.Identifier)] = img
+	}
+	after := make(map[string]CategorizedImage)
+	for _, img := range new.Images {
+		key := imageKey(img.Category, img.Identifier)
+		after[key] = img
+		prev, ok := before[key]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, key)
+		case prev.Path != img.Path || !prev.modTime.Equal(img.modTime) || prev.size != img.size:
+			c.Modified = append(c.Modified, key)
+		case !reflect.DeepEqual(prev.Metadata, img.Metadata):
+			c.Metadata = append(c.Metadata, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			c.Removed = append(c.Removed, key)
+		}
+	}
+	c.VideosAdded = difference(videoNames(new), videoNames(old))
+	c.VideosRemoved = difference(videoNames(old), videoNames(new))
+	for _, v := range [][]string{c.Added, c.Removed, c.Modified, c.Metadata} {
+		sort.Strings(v)
+	}
+	return c
+}
+
+// videoNames returns the file names of all video sources.
+func videoNames(inv *Inventory) (names []string) {
+	for _, v := range inv.Videos {
+		for _, src := range v.Sources {
+			names = append(names, src.Name)
+		}
+	}
+	return names
+}
+
+// difference returns the elements of a, which are not in b.
+func difference(a, b []string) (result []string) {
+	m := make(map[string]bool)
+	for _, v := range b {
+		m[v] = true
+	}
+	for _, v := range a {
+		if !m[v] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// ReloadInventory rescans image and video directories and replaces the
+// inventory, if the result is usable. Random source and display history are
+// kept.
+func (app *App) ReloadInventory() (InventoryChanges, error) {
+	_, changes, err := app.reloadInventory()
+	return changes, err
+}
+
+// reloadInventory reloads the inventory and returns the replaced one as well.
+func (app *App) reloadInventory() (*Inventory, InventoryChanges, error) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+	old := app.Inventory()
+	inv, err := createInventory(app.imagesDir, app.videosDir, old.Slots)
+	if err != nil {
+		return nil, InventoryChanges{}, err
+	}
+	if !inv.Ok() {
+		return nil, InventoryChanges{}, fmt.Errorf("incomplete inventory for slots %s, keeping current", old.Slots)
+	}
+	changes := diffInventory(old, inv)
+	if changes.Empty() {
+		return old, changes, nil
+	}
+	inv.sel = old.selection()
+	app.mu.Lock()
+	app.inventory = inv
+	app.mu.Unlock()
+	return old, changes, nil
+}
+
+// invalidateCache removes cached composites and animations, which contain a
+// removed or modified image. Animations are checked against the reels drawn
+// from the old inventory. It returns the number of removed files.
+func invalidateCache(c *Compositor, old *Inventory, changes InventoryChanges) (int, error) {
+	stale := make(map[string]bool)
+	for _, key := range append(changes.Removed, changes.Modified...) {
+		stale[key] = true
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	return c.RemoveIf(func(name string) bool {
+		if iid, opts, ok := parseAnimationName(name); ok {
+			cid, err := old.ParseCompositeID(iid)
+			if err != nil {
+				// Not rendered from the old inventory, cannot tell.
+				return true
+			}
+			for _, reel := range animationReels(old, cid, opts) {
+				for _, img := range reel {
+					if stale[imageKey(img.Category, img.Identifier)] {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		ids, err := old.Slots.Split(compositeNameIdentifier(name))
+		if err != nil {
+			return false
+		}
+		for i, slot := range old.Slots {
+			if stale[imageKey(slot.Category, ids[i])] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ReloadInventory reloads the inventory, drops affected cached composites and
+// logs the changes.
+func (h *Handler) ReloadInventory() error {
+	old, changes, err := h.App.reloadInventory()
+	if err != nil {
+		return err
+	}
+	if changes.Empty() {
+		return nil
+	}
+	log.Printf("inventory reloaded, %s", changes)
+	n, err := invalidateCache(h.Compositor, old, changes)
+	if n > 0 {
+		log.Printf("removed %d cached composite(s)", n)
+	}
+	return err
+}
+
+// WatchInventory rescans the inventory periodically. It does not return.
+func (h *Handler) WatchInventory(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := h.ReloadInventory(); err != nil {
+			log.Printf("inventory reload failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package dvmweb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDiffInventory(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := &Inventory{
+		Images: []CategorizedImage{
+			{Identifier: "00", Category: "artifacts", Path: "a/00.jpg", modTime: t0, size: 10},
+			{Identifier: "01", Category: "artifacts", Path: "a/01.jpg", modTime: t0, size: 10},
+			{Identifier: "02", Category: "artifacts", Path: "a/02.jpg", modTime: t0, size: 10},
+			{Identifier: "03", Category: "artifacts", Path: "a/03.jpg", modTime: t0, size: 10},
+			{Identifier: "04", Category: "artifacts", Path: "a/04.jpg", modTime: t0, size: 10},
+			{Identifier: "00", Category: "people", Path: "p/00.jpg", modTime: t0, size: 10},
+		},
+		Videos: []Video{
+			{Identifier: "010203", Sources: []VideoSource{{Name: "dvm-010203.webm"}, {Name: "dvm-010203.mp4"}}},
+		},
+	}
+	new := &Inventory{
+		Images: []CategorizedImage{
+			{Identifier: "00", Category: "artifacts", Path: "a/00.jpg", modTime: t0, size: 10},
+			{Identifier: "01", Category: "artifacts", Path: "a/01.jpg", modTime: t0.Add(time.Second), size: 10},
+			{Identifier: "02", Category: "artifacts", Path: "a/02.jpg", modTime: t0, size: 11},
+			{Identifier: "03", Category: "artifacts", Path: "a/03.png", modTime: t0, size: 10},
+			{Identifier: "04", Category: "artifacts", Path: "a/04.jpg", modTime: t0, size: 10,
+				Metadata: &ImageMetadata{Title: "Spinnrad"}},
+			{Identifier: "05", Category: "artifacts", Path: "a/05.jpg", modTime: t0, size: 10},
+		},
+		Videos: []Video{
+			{Identifier: "010203", Sources: []VideoSource{{Name: "dvm-010203.webm"}}},
+			{Identifier: "040506", Sources: []VideoSource{{Name: "dvm-040506.webm"}}},
+		},
+	}
+	want := InventoryChanges{
+		Added:         []string{"artifacts/05"},
+		Removed:       []string{"people/00"},
+		Modified:      []string{"artifacts/01", "artifacts/02", "artifacts/03"},
+		Metadata:      []string{"artifacts/04"},
+		VideosAdded:   []string{"dvm-040506.webm"},
+		VideosRemoved: []string{"dvm-010203.mp4"},
+	}
+	if got := diffInventory(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if c := diffInventory(old, old); !c.Empty() {
+		t.Errorf("same inventory: got %s", c)
+	}
+}
+
+func TestInvalidateCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvmweb-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inv := disjointInventory()
+	changes := InventoryChanges{Removed: []string{"artifacts/01"}, Modified: []string{"landscapes/29"}}
+
+	// Two images per reel, one drawn, one of the combination.
+	opts := AnimationOptions{Frames: 1, Tiles: 2, Easing: "linear"}
+	var (
+		files = []string{".keep", "notes.txt"}
+		want  []string
+		// Whether an animation is dropped only for a drawn image.
+		drawn, kept bool
+	)
+	for _, v := range []struct {
+		name  string
+		stale bool
+	}{
+		{"011020.jpg", true},
+		{"001020-w480.webp", false},
+		{"001020.png", false},
+		{"001129-w1920.jpg", true},
+	} {
+		files = append(files, v.name)
+		if v.stale {
+			want = append(want, v.name)
+		}
+	}
+	for _, iid := range []string{"001020", "021121", "031222", "041323", "051424", "061525", "071626", "081727"} {
+		cid, err := inv.ParseCompositeID(iid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := animationName(cid, opts)
+		files = append(files, name)
+		var stale bool
+		for _, reel := range animationReels(inv, cid, opts) {
+			for _, img := range reel {
+				if img.Category == "artifacts" && img.Identifier == "01" ||
+					img.Category == "landscapes" && img.Identifier == "29" {
+					stale = true
+				}
+			}
+		}
+		if stale {
+			want = append(want, name)
+			drawn = true
+		} else {
+			kept = true
+		}
+	}
+	if !drawn || !kept {
+		t.Fatalf("fixture does not cover both cases, drawn %v, kept %v", drawn, kept)
+	}
+	for _, name := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewCompositor(dir, 1, CacheLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := invalidateCache(c, inv, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Errorf("got %d removed, want %d", n, len(want))
+	}
+	var got []string
+	for _, name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			got = append(got, name)
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v removed, want %v", got, want)
+	}
+	if n, err := invalidateCache(c, inv, InventoryChanges{Added: []string{"artifacts/10"}}); n != 0 || err != nil {
+		t.Errorf("added only: got %d, %v", n, err)
+	}
+}
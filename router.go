@@ -0,0 +1,62 @@
+package dvmweb
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NewRouter sets up all routes. Limiters are looked up by route, "write",
+// "report" and "login", and must all be present.
+func NewRouter(h *Handler, limiters map[string]*RateLimiter) *mux.Router {
+	r := mux.NewRouter()
+
+	// Server static assets of defined dir.
+	fs := http.FileServer(http.Dir(h.StaticDir))
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static", fs))
+	// Handlers.
+	r.HandleFunc("/c/{iid}@{scale:[0-9]+}x.{ext:jpg|jpeg|png|webp}", h.CompositeHandler)
+	r.HandleFunc("/c/{iid}.{ext:jpg|jpeg|png|webp}", h.CompositeHandler)
+	r.HandleFunc("/a/{iid}.gif", h.AnimationHandler)
+	r.HandleFunc("/w/{iid}", h.RateLimit(limiters["write"], h.WriteHandler))
+	r.HandleFunc("/r/{iid}", h.ReadHandler)
+	r.HandleFunc("/r/{iid}/feed.atom", h.AtomHandler)
+	r.HandleFunc("/r/{iid}/feed.rss", h.RSSHandler)
+	r.HandleFunc("/s/{id}", h.StoryHandler)
+	r.HandleFunc("/v/{vid}", h.VideoHandler)
+	r.HandleFunc("/s/{id}/report", h.RateLimit(limiters["report"], h.ReportHandler))
+	r.HandleFunc("/admin", h.RequireCurator(h.AdminHandler))
+	r.HandleFunc("/admin/login", h.RateLimit(limiters["login"], h.LoginHandler))
+	r.HandleFunc("/admin/logout", h.LogoutHandler)
+	r.HandleFunc("/admin/moderation", h.RequireCurator(h.ModerationHandler))
+	r.HandleFunc("/admin/stories/{id}", h.RequireCurator(h.EditStoryHandler))
+	r.HandleFunc("/admin/stories/{id}/delete", h.RequireCurator(h.DeleteStoryHandler))
+	r.HandleFunc("/admin/cache/clear", h.RequireCurator(h.ClearCacheHandler))
+	r.HandleFunc("/", h.IndexHandler)
+	r.HandleFunc("/rand", h.RandomRead)
+	r.HandleFunc("/about", h.AboutHandler)
+	r.HandleFunc("/search", h.SearchHandler)
+	r.HandleFunc("/feed.atom", h.AtomHandler)
+	r.HandleFunc("/feed.rss", h.RSSHandler)
+	// JSON API.
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/stories", h.APIStoriesHandler).Methods("GET")
+	api.HandleFunc("/stories", h.RateLimit(limiters["write"], h.APICreateStoryHandler)).Methods("POST")
+	api.HandleFunc("/stories/{id:[0-9]+}", h.APIStoryHandler).Methods("GET")
+	api.HandleFunc("/images/{iid}/stories", h.APIImageStoriesHandler).Methods("GET")
+	api.HandleFunc("/random", h.APIRandomHandler).Methods("GET")
+	api.HandleFunc("/categories", h.APICategoriesHandler).Methods("GET")
+	api.HandleFunc("/videos", h.APIVideosHandler).Methods("GET")
+	api.HandleFunc("/search", h.APISearchHandler).Methods("GET")
+	api.NotFoundHandler = http.HandlerFunc(h.APINotFoundHandler)
+	api.MethodNotAllowedHandler = http.HandlerFunc(h.APIMethodNotAllowedHandler)
+	r.HandleFunc("/api/openapi.json", h.OpenAPIHandler)
+	r.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/static/robots.txt", 302)
+	})
+	r.HandleFunc("/humans.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/static/humans.txt", 302)
+	})
+	r.NotFoundHandler = http.HandlerFunc(h.NotFoundHandler)
+	return r
+}
@@ -0,0 +1,169 @@
+package dvmweb
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchQuery describes a full text search over stories.
+type SearchQuery struct {
+	Text     string // Whitespace separated terms, all must match.
+	Language string // Optional language filter, e.g. "ger" or "hsb".
+	Limit    int
+}
+
+// Terms returns the lowercased search terms, words split like the full text
+// index does.
+func (q SearchQuery) Terms() []string {
+	return words(q.Text)
+}
+
+// isWordRune returns true for letters and digits.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// words splits a text into lowercased runs of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// indexWordStart returns the index of the first occurrence of t in s at or
+// after from, which starts a word, or -1.
+func indexWordStart(s, t string, from int) int {
+	for from <= len(s) {
+		j := strings.Index(s[from:], t)
+		if j < 0 {
+			return -1
+		}
+		j += from
+		if r, _ := utf8.DecodeLastRuneInString(s[:j]); j == 0 || !isWordRune(r) {
+			return j
+		}
+		from = j + 1
+	}
+	return -1
+}
+
+// SearchResult is a story, along with a highlighted snippet of the match.
+type SearchResult struct {
+	Story   Story  `json:"story"`
+	Snippet string `json:"snippet"`
+}
+
+// snippetContext is the number of bytes shown before and after the first match.
+const snippetContext = 80
+
+// highlight returns an HTML escaped excerpt of text around the first matching
+// term with all occurrences of terms at the start of a word wrapped in mark
+// elements, like the full text index matches them.
+func highlight(text string, terms []string) string {
+	var (
+		lower = strings.ToLower(text)
+		first = -1
+	)
+	if len(lower) != len(text) {
+		// Lowercasing changed byte offsets, give up on highlighting.
+		return html.EscapeString(text)
+	}
+	for _, t := range terms {
+		if i := indexWordStart(lower, t, 0); i >= 0 && (first == -1 || i < first) {
+			first = i
+		}
+	}
+	start, end := 0, len(text)
+	if first > snippetContext {
+		start = first - snippetContext
+	}
+	if end-first > 2*snippetContext && first >= 0 {
+		end = first + 2*snippetContext
+	}
+	// Do not cut into multibyte characters.
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	var (
+		buf   strings.Builder
+		i     = start
+		chunk = lower[:end]
+	)
+	if start > 0 {
+		buf.WriteString("… ")
+	}
+	for i < end {
+		next, length := -1, 0
+		for _, t := range terms {
+			if t == "" {
+				continue
+			}
+			if j := indexWordStart(chunk, t, i); j >= 0 && (next == -1 || j < next) {
+				next, length = j, len(t)
+			}
+		}
+		if next == -1 {
+			buf.WriteString(html.EscapeString(text[i:end]))
+			break
+		}
+		buf.WriteString(html.EscapeString(text[i:next]))
+		buf.WriteString("<mark>")
+		buf.WriteString(html.EscapeString(text[next : next+length]))
+		buf.WriteString("</mark>")
+		i = next + length
+	}
+	if end < len(text) {
+		buf.WriteString(" …")
+	}
+	return buf.String()
+}
+
+// matchesAll returns true, if every term is a prefix of a word in text,
+// ignoring case, like a FTS5 prefix query.
+func matchesAll(text string, terms []string) bool {
+	ws := words(text)
+	for _, t := range terms {
+		found := false
+		for _, w := range ws {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ftsQuery turns search terms into a FTS5 prefix query, quoting each term, so
+// user input cannot trigger syntax errors.
+func ftsQuery(terms []string) string {
+	var quoted []string
+	for _, t := range terms {
+		quoted = append(quoted, `"`+strings.Replace(t, `"`, `""`, -1)+`"*`)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// escapeLike escapes the LIKE wildcards % and _ with a backslash, for use
+// with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// titleCase returns a term with its first letter in upper case, if that
+// letter is not ASCII, and the term itself otherwise.
+func titleCase(t string) string {
+	r, n := utf8.DecodeRuneInString(t)
+	if r < utf8.RuneSelf || r == utf8.RuneError {
+		return t
+	}
+	return string(unicode.ToUpper(r)) + t[n:]
+}
@@ -0,0 +1,117 @@
+package dvmweb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchesAll(t *testing.T) {
+	var cases = []struct {
+		text  string
+		query string
+		want  bool
+	}{
+		{"Ein Feld voller Flachs", "fla", true},
+		{"Ein Feld voller Flachs", "FLACHS feld", true},
+		{"Ein Feld voller Flachs", "lachs", false},
+		{"Schöne Füße", "füß", true},
+		{"ŁUŽICA", "łuž", true},
+		{"100% sicher", "100%", true},
+		{"snake_case", "case", true},
+		{"snake_case", "e_c", false},
+	}
+	for _, c := range cases {
+		terms := SearchQuery{Text: c.query}.Terms()
+		if got := matchesAll(c.text, terms); got != c.want {
+			t.Errorf("matchesAll(%q, %q) = %v, want %v", c.text, terms, got, c.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	var cases = []struct {
+		s    string
+		want string
+	}{
+		{"abc", "abc"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`a\b`, `a\\b`},
+	}
+	for _, c := range cases {
+		if got := escapeLike(c.s); got != c.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", c.s, got, c.want)
+		}
+	}
+}
+
+func TestFtsQuery(t *testing.T) {
+	if got, want := ftsQuery([]string{"fla", `a"b`}), `"fla"* "a""b"*`; got != want {
+		t.Errorf("ftsQuery = %q, want %q", got, want)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	var cases = []struct {
+		text  string
+		query string
+		want  string
+	}{
+		{"Ein Feld voller Flachs.", "fla", "Ein Feld voller <mark>Fla</mark>chs."},
+		{"Flachs und Lachs", "lachs", "Flachs und <mark>Lachs</mark>"},
+		{"Flachs", "lachs", "Flachs"},
+		{"a <b> Feld", "feld", "a &lt;b&gt; <mark>Feld</mark>"},
+		{"Feld, feld", "feld ein", "<mark>Feld</mark>, <mark>feld</mark>"},
+		{"„Čłowjek“ a čłowjek", "čł", "„<mark>Čł</mark>owjek“ a <mark>čł</mark>owjek"},
+	}
+	for _, c := range cases {
+		if got := highlight(c.text, SearchQuery{Text: c.query}.Terms()); got != c.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", c.text, c.query, got, c.want)
+		}
+	}
+}
+
+func TestIndexWordStart(t *testing.T) {
+	var cases = []struct {
+		s, t string
+		from int
+		want int
+	}{
+		{"flachs lachs", "lachs", 0, 7},
+		{"flachs lachs", "fla", 0, 0},
+		{"flachs lachs", "fla", 1, -1},
+		{"flachs", "lachs", 0, -1},
+		{"(lachs)", "lachs", 0, 1},
+		{"člachs lachs", "lachs", 0, 8},
+	}
+	for _, c := range cases {
+		if got := indexWordStart(c.s, c.t, c.from); got != c.want {
+			t.Errorf("indexWordStart(%q, %q, %d) = %d, want %d", c.s, c.t, c.from, got, c.want)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	var cases = []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Flachs  Feld", []string{"flachs", "feld"}},
+		{"„Čłowjek“, 100%", []string{"čłowjek", "100"}},
+		{`a"b c_d`, []string{"a", "b", "c", "d"}},
+	}
+	for _, c := range cases {
+		if got := (SearchQuery{Text: c.text}).Terms(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Terms(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestTitleCase(t *testing.T) {
+	for s, want := range map[string]string{"čłowjek": "Čłowjek", "flachs": "flachs", "ůž": "Ůž", "": ""} {
+		if got := titleCase(s); got != want {
+			t.Errorf("titleCase(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
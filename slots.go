@@ -0,0 +1,100 @@
+package dvmweb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Slot is a position in a composite image, filled with an image from a
+// category. The identifiers of images in this category have a fixed width.
+type Slot struct {
+	Category string
+	Width    int
+}
+
+// Slots describe the layout of the machine, from left to right. A composite
+// image identifier is the concatenation of the image identifiers of all slots,
+// e.g. "010203" for three slots of width two.
+type Slots []Slot
+
+// DefaultSlots is the original machine: an artifact, people and a landscape.
+var DefaultSlots = Slots{
+	{Category: "artifacts", Width: 2},
+	{Category: "people", Width: 2},
+	{Category: "landscapes", Width: 2},
+}
+
+// Size of a single slot in a composite image, images are resized to the tile
+// height and placed next to each other.
+const (
+	TileWidth  = 320
+	TileHeight = 300
+)
+
+// ParseSlots parses a comma separated list of category:width pairs, e.g.
+// "artifacts:2,people:2,landscapes:2".
+func ParseSlots(s string) (slots Slots, err error) {
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid slot %q, want category:width", v)
+		}
+		w, err := strconv.Atoi(parts[1])
+		if err != nil || w < 1 {
+			return nil, fmt.Errorf("invalid slot width in %q", v)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("duplicate slot category: %s", parts[0])
+		}
+		seen[parts[0]] = true
+		slots = append(slots, Slot{Category: parts[0], Width: w})
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("no slots given")
+	}
+	return slots, nil
+}
+
+// String formats slots in the form accepted by ParseSlots.
+func (s Slots) String() string {
+	var parts []string
+	for _, slot := range s {
+		parts = append(parts, fmt.Sprintf("%s:%d", slot.Category, slot.Width))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Width returns the length of a composite identifier.
+func (s Slots) Width() (w int) {
+	for _, slot := range s {
+		w += slot.Width
+	}
+	return w
+}
+
+// Split cuts a composite identifier into the image identifiers per slot.
+func (s Slots) Split(iid string) ([]string, error) {
+	if len(iid) != s.Width() {
+		return nil, fmt.Errorf("image id of length %d expected, got %q", s.Width(), iid)
+	}
+	var (
+		ids    []string
+		offset int
+	)
+	for _, slot := range s {
+		ids = append(ids, iid[offset:offset+slot.Width])
+		offset += slot.Width
+	}
+	return ids, nil
+}
+
+// CanvasSize returns the dimensions of a composite image.
+func (s Slots) CanvasSize() (width, height int) {
+	return TileWidth * len(s), TileHeight
+}
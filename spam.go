@@ -0,0 +1,228 @@
+package dvmweb
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Verdict of a spam check.
+type Verdict int
+
+const (
+	Ham        Verdict = iota // Looks fine.
+	Suspicious                // Save, but hide until a curator had a look.
+	Spam                      // Reject.
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Ham:
+		return "ham"
+	case Suspicious:
+		return "suspicious"
+	case Spam:
+		return "spam"
+	default:
+		return fmt.Sprintf("verdict(%d)", int(v))
+	}
+}
+
+// Labels curators can attach to stories to train the classifier.
+const (
+	LabelSpam = "spam"
+	LabelHam  = "ham"
+)
+
+// SpamChecker inspects a story before it is saved. The reason is meant for
+// logging and should be set, if the verdict is not Ham.
+type SpamChecker interface {
+	CheckSpam(r *http.Request, s Story) (v Verdict, reason string, err error)
+}
+
+// Trainer is implemented by checkers, which learn from curator labels.
+type Trainer interface {
+	Train() error
+}
+
+// HoneypotChecker rejects submissions, which fill out a form field hidden
+// from humans.
+type HoneypotChecker struct {
+	Field string
+}
+
+// CheckSpam returns Spam, if the honeypot field is not empty.
+func (c HoneypotChecker) CheckSpam(r *http.Request, s Story) (Verdict, string, error) {
+	if r.FormValue(c.Field) != "" {
+		return Spam, fmt.Sprintf("honeypot field %s filled", c.Field), nil
+	}
+	return Ham, "", nil
+}
+
+// linkPattern matches things, that look like links.
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// LinkChecker flags stories with too many links or with a high ratio of
+// links to words, which is unusual for stories, but common for spam.
+type LinkChecker struct {
+	MaxLinks   int     // Maximum number of links allowed.
+	MaxDensity float64 // Maximum fraction of words, that are links.
+}
+
+// CheckSpam counts links in the story text.
+func (c LinkChecker) CheckSpam(r *http.Request, s Story) (Verdict, string, error) {
+	links := len(linkPattern.FindAllString(s.Text, -1))
+	if links == 0 {
+		return Ham, "", nil
+	}
+	if links > c.MaxLinks {
+		return Suspicious, fmt.Sprintf("%d links", links), nil
+	}
+	words := len(strings.Fields(s.Text))
+	if density := float64(links) / float64(words); density > c.MaxDensity {
+		return Suspicious, fmt.Sprintf("link density %0.2f", density), nil
+	}
+	return Ham, "", nil
+}
+
+// RepeatChecker rejects texts, which have already been posted recently.
+type RepeatChecker struct {
+	Store  Store
+	Window int // Number of recent stories to compare against.
+}
+
+// normalizeText lowercases and collapses whitespace and punctuation.
+func normalizeText(s string) string {
+	return strings.Join(tokenize(s), " ")
+}
+
+// CheckSpam compares the normalized text against recent public stories, so
+// a text removed by a curator can be posted again. Texts without any words
+// are left to other checks.
+func (c RepeatChecker) CheckSpam(r *http.Request, s Story) (Verdict, string, error) {
+	text := normalizeText(s.Text)
+	if text == "" {
+		return Ham, "", nil
+	}
+	recent, err := c.Store.RecentStories(c.Window, 0)
+	if err != nil {
+		return Ham, "", err
+	}
+	for _, other := range recent {
+		if normalizeText(other.Text) == text {
+			return Spam, fmt.Sprintf("repeats story %d", other.Identifier), nil
+		}
+	}
+	return Ham, "", nil
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+}
+
+// NaiveBayes is a simple two class, multinomial naive Bayes classifier with
+// Laplace smoothing.
+type NaiveBayes struct {
+	docs   map[string]int            // Number of documents per label.
+	words  map[string]map[string]int // Word counts per label.
+	totals map[string]int            // Total words per label.
+	vocab  map[string]struct{}
+}
+
+// NewNaiveBayes returns an untrained classifier.
+func NewNaiveBayes() *NaiveBayes {
+	return &NaiveBayes{
+		docs:   make(map[string]int),
+		words:  map[string]map[string]int{LabelSpam: {}, LabelHam: {}},
+		totals: make(map[string]int),
+		vocab:  make(map[string]struct{}),
+	}
+}
+
+// Add trains the classifier with a single labeled document.
+func (nb *NaiveBayes) Add(label, text string) {
+	if label != LabelSpam && label != LabelHam {
+		return
+	}
+	nb.docs[label]++
+	for _, t := range tokenize(text) {
+		nb.words[label][t]++
+		nb.totals[label]++
+		nb.vocab[t] = struct{}{}
+	}
+}
+
+// Documents returns the number of training documents for a label.
+func (nb *NaiveBayes) Documents(label string) int {
+	return nb.docs[label]
+}
+
+// SpamProbability returns the posterior probability, that a text is spam.
+func (nb *NaiveBayes) SpamProbability(text string) float64 {
+	total := nb.docs[LabelSpam] + nb.docs[LabelHam]
+	if total == 0 {
+		return 0.5
+	}
+	var (
+		v      = float64(len(nb.vocab))
+		scores = make(map[string]float64)
+	)
+	for _, label := range []string{LabelSpam, LabelHam} {
+		score := math.Log(float64(nb.docs[label]+1) / float64(total+2))
+		for _, t := range tokenize(text) {
+			score += math.Log(float64(nb.words[label][t]+1) / (float64(nb.totals[label]) + v))
+		}
+		scores[label] = score
+	}
+	// Normalize in log space, 1 / (1 + exp(ham - spam)).
+	return 1 / (1 + math.Exp(scores[LabelHam]-scores[LabelSpam]))
+}
+
+// BayesChecker flags stories, which a naive Bayes classifier trained on
+// curator labels considers spam.
+type BayesChecker struct {
+	Store        Store
+	Threshold    float64 // Spam probability above which stories are flagged.
+	MinDocuments int     // Minimum number of examples per label before checking.
+
+	mu    sync.RWMutex
+	model *NaiveBayes
+}
+
+// Train rebuilds the model from all labeled stories.
+func (c *BayesChecker) Train() error {
+	stories, err := c.Store.LabeledStories()
+	if err != nil {
+		return err
+	}
+	model := NewNaiveBayes()
+	for _, s := range stories {
+		model.Add(s.Label, s.Text)
+	}
+	c.mu.Lock()
+	c.model = model
+	c.mu.Unlock()
+	return nil
+}
+
+// CheckSpam classifies the story text.
+func (c *BayesChecker) CheckSpam(r *http.Request, s Story) (Verdict, string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.model == nil ||
+		c.model.Documents(LabelSpam) < c.MinDocuments ||
+		c.model.Documents(LabelHam) < c.MinDocuments {
+		return Ham, "", nil
+	}
+	if p := c.model.SpamProbability(s.Text); p > c.Threshold {
+		return Suspicious, fmt.Sprintf("spam probability %0.3f", p), nil
+	}
+	return Ham, "", nil
+}
@@ -0,0 +1,135 @@
+package dvmweb
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHoneypotChecker(t *testing.T) {
+	c := HoneypotChecker{Field: "website"}
+	for _, v := range []struct {
+		form url.Values
+		want Verdict
+	}{
+		{url.Values{"story": {"Flachs."}}, Ham},
+		{url.Values{"story": {"Flachs."}, "website": {""}}, Ham},
+		{url.Values{"story": {"Flachs."}, "website": {"http://example.com"}}, Spam},
+	} {
+		r := httptest.NewRequest("POST", "/w/010203", strings.NewReader(v.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if got, _, err := c.CheckSpam(r, Story{}); err != nil || got != v.want {
+			t.Errorf("%v: got %s, %v, want %s", v.form, got, err, v.want)
+		}
+	}
+}
+
+func TestLinkChecker(t *testing.T) {
+	c := LinkChecker{MaxLinks: 2, MaxDensity: 0.2}
+	for _, v := range []struct {
+		text string
+		want Verdict
+	}{
+		{"Ein Feld voller Flachs.", Ham},
+		{"Mehr dazu auf https://example.com, dort gibt es Bilder vom Feld und vom Hof.", Ham},
+		{"http://a.example www.b.example https://c.example und viele Worte mehr, die hier stehen, noch mehr.", Suspicious},
+		{"Hier: www.example.com", Suspicious},
+	} {
+		if got, _, err := c.CheckSpam(nil, Story{Text: v.text}); err != nil || got != v.want {
+			t.Errorf("%q: got %s, %v, want %s", v.text, got, err, v.want)
+		}
+	}
+}
+
+func TestRepeatChecker(t *testing.T) {
+	s := NewMemoryStore()
+	for _, story := range []Story{
+		{ImageIdentifier: "010203", Text: "Ein Feld voller Flachs.", Status: StatusVisible},
+		{ImageIdentifier: "010203", Text: "Versteckt und vergessen.", Status: StatusVisible},
+		{ImageIdentifier: "010203", Text: "Noch nicht geprüft.", Status: StatusFlagged},
+		{ImageIdentifier: "010203", Text: "...", Status: StatusVisible},
+	} {
+		if _, err := s.InsertStory(story); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Moderate(2, ActionHide, "test"); err != nil {
+		t.Fatal(err)
+	}
+	c := RepeatChecker{Store: s, Window: 10}
+	for _, v := range []struct {
+		text string
+		want Verdict
+	}{
+		{"Ein Feld voller Flachs.", Spam},
+		{"  ein FELD, voller   flachs!", Spam},
+		{"Ein Feld voller Leinen.", Ham},
+		{"Versteckt und vergessen.", Ham},
+		{"Noch nicht geprüft.", Ham},
+		{"?!", Ham},
+		{"", Ham},
+	} {
+		if got, _, err := c.CheckSpam(nil, Story{Text: v.text}); err != nil || got != v.want {
+			t.Errorf("%q: got %s, %v, want %s", v.text, got, err, v.want)
+		}
+	}
+}
+
+func TestNaiveBayes(t *testing.T) {
+	nb := NewNaiveBayes()
+	if p := nb.SpamProbability("irgendwas"); p != 0.5 {
+		t.Errorf("untrained: got %v, want 0.5", p)
+	}
+	nb.Add(LabelSpam, "cheap pills buy now")
+	nb.Add(LabelSpam, "buy cheap watches now")
+	nb.Add(LabelHam, "Die Mittagsfrau kam über das Feld")
+	nb.Add(LabelHam, "Wir banden den Flachs auf dem Feld")
+	nb.Add("other", "ignored")
+	if n := nb.Documents(LabelSpam); n != 2 {
+		t.Errorf("got %d spam documents, want 2", n)
+	}
+	if p := nb.SpamProbability("buy cheap pills"); p <= 0.5 {
+		t.Errorf("spam: got %v", p)
+	}
+	if p := nb.SpamProbability("Flachs auf dem Feld"); p >= 0.5 {
+		t.Errorf("ham: got %v", p)
+	}
+}
+
+func TestBayesChecker(t *testing.T) {
+	s := NewMemoryStore()
+	c := &BayesChecker{Store: s, Threshold: 0.9, MinDocuments: 2}
+	spam := Story{Text: "buy cheap pills now"}
+	if v, _, err := c.CheckSpam(nil, spam); err != nil || v != Ham {
+		t.Errorf("untrained: got %s, %v", v, err)
+	}
+	for _, story := range []Story{
+		{ImageIdentifier: "010203", Text: "cheap pills buy now", Label: LabelSpam},
+		{ImageIdentifier: "010203", Text: "buy cheap pills today", Label: LabelSpam},
+		{ImageIdentifier: "010203", Text: "Die Mittagsfrau kam über das Feld", Label: LabelHam},
+	} {
+		if _, err := s.InsertStory(story); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Train(); err != nil {
+		t.Fatal(err)
+	}
+	// A single ham example is below MinDocuments.
+	if v, _, err := c.CheckSpam(nil, spam); err != nil || v != Ham {
+		t.Errorf("too few examples: got %s, %v", v, err)
+	}
+	if _, err := s.InsertStory(Story{ImageIdentifier: "010203", Text: "Wir banden den Flachs", Label: LabelHam}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Train(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, err := c.CheckSpam(nil, spam); err != nil || v != Suspicious {
+		t.Errorf("trained: got %s, %v, want suspicious", v, err)
+	}
+	if v, _, err := c.CheckSpam(nil, Story{Text: "Flachs auf dem Feld"}); err != nil || v != Ham {
+		t.Errorf("trained: got %s, %v, want ham", v, err)
+	}
+}
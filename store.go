@@ -0,0 +1,937 @@
+package dvmweb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrStoryNotFound is returned, if a story cannot be found in a store.
+var ErrStoryNotFound = errors.New("story not found")
+
+// Store abstracts away access to stories, so handlers do not need to know
+// about SQL or a particular database.
+type Store interface {
+	// StoriesByImage returns all public stories for a given image identifier,
+	// newest first.
+	StoriesByImage(iid string) ([]Story, error)
+	// StoryByID returns a single story, regardless of its moderation status,
+	// or ErrStoryNotFound.
+	StoryByID(id int) (*Story, error)
+	// RecentStories returns at most limit public stories, newest first,
+	// skipping offset stories.
+	RecentStories(limit, offset int) ([]Story, error)
+	// StoriesByLanguage returns at most limit public stories in a given
+	// language, newest first.
+	StoriesByLanguage(lang string, limit int) ([]Story, error)
+	// StoryCounts returns the number of public stories per image identifier,
+	// for images with at least one story.
+	StoryCounts() (map[string]int, error)
+	// InsertStory saves a new story and returns its identifier.
+	InsertStory(s Story) (int64, error)
+	// SearchStories returns public stories matching all terms of a query.
+	SearchStories(q SearchQuery) ([]Story, error)
+	// ReportStory records a report and flags the story for review, unless a
+	// curator has already approved it.
+	ReportStory(id int, ip, reason string) error
+	// ModerationQueue returns flagged stories along with their reports.
+	ModerationQueue() ([]QueueItem, error)
+	// Moderate applies a curator decision and records it in the audit log.
+	Moderate(id int, action, curator string) error
+	// ModerationLog returns the most recent curator decisions.
+	ModerationLog(limit int) ([]ModerationEntry, error)
+	// AllStories returns stories regardless of status, newest first.
+	AllStories(limit, offset int) ([]Story, error)
+	// UpdateStory changes text, language and status of a story and records
+	// the edit in the moderation log.
+	UpdateStory(s Story, curator string) error
+	// LabeledStories returns all stories curators marked as spam or ham.
+	LabeledStories() ([]Story, error)
+
+	// AddCurator creates a curator with a password hash or changes the
+	// password of an existing one, which ends all its sessions.
+	AddCurator(name, hash string) error
+	// RemoveCurator deletes a curator and all sessions.
+	RemoveCurator(name string) error
+	// Curators returns all curators.
+	Curators() ([]Curator, error)
+	// CuratorByName returns a curator or ErrCuratorNotFound.
+	CuratorByName(name string) (*Curator, error)
+	// CreateSession stores a new session token for a curator.
+	CreateSession(token, curator string, expires time.Time) error
+	// SessionCurator returns the curator for a valid session token or
+	// ErrSessionNotFound.
+	SessionCurator(token string) (string, error)
+	// DeleteSession removes a session.
+	DeleteSession(token string) error
+}
+
+// public is the SQL condition for stories, that can be shown to visitors.
+var public = fmt.Sprintf(`flagged IN (%d, %d)`, StatusVisible, StatusApproved)
+
+// SQLStore keeps stories in a database, accessed via sqlx. Queries are
+// written with question mark placeholders and rebound for the driver in use.
+type SQLStore struct {
+	db       *sqlx.DB
+	fullText bool // Whether the story_fts table is available.
+}
+
+// NewSQLStore wraps a database handle.
+func NewSQLStore(db *sqlx.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// StoriesByImage returns all stories for a given image identifier.
+func (s *SQLStore) StoriesByImage(iid string) (stories []Story, err error) {
+	err = s.db.Select(&stories, s.db.Rebind(`
+	SELECT id, imageid, text, language, flagged, created
+	FROM story WHERE imageid = ? AND `+public+`
+	ORDER BY created DESC`), iid)
+	return
+}
+
+// StoryByID returns a single story.
+func (s *SQLStore) StoryByID(id int) (*Story, error) {
+	var story Story
+	err := s.db.Get(&story, s.db.Rebind(`
+	SELECT id, imageid, text, language, flagged, created
+	FROM story WHERE id = ? LIMIT 1`), id)
+	if err == sql.ErrNoRows {
+		return nil, ErrStoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &story, nil
+}
+
+// RecentStories returns the most recent stories.
+func (s *SQLStore) RecentStories(limit, offset int) (stories []Story, err error) {
+	err = s.db.Select(&stories, s.db.Rebind(`
+	SELECT id, imageid, text, language, flagged, created
+	FROM story WHERE `+public+` ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`), limit, offset)
+	return
+}
+
+// StoriesByLanguage returns the most recent stories in a language.
+func (s *SQLStore) StoriesByLanguage(lang string, limit int) (stories []Story, err error) {
+	err = s.db.Select(&stories, s.db.Rebind(`
+	SELECT id, imageid, text, language, flagged, created
+	FROM story WHERE language = ? AND `+public+` ORDER BY created DESC, id DESC LIMIT ?`), lang, limit)
+	return
+}
+
+// StoryCounts returns the number of stories per image.
+func (s *SQLStore) StoryCounts() (map[string]int, error) {
+	var rows []struct {
+		ImageIdentifier string `db:"imageid"`
+		Count           int    `db:"n"`
+	}
+	if err := s.db.Select(&rows, `
+	SELECT imageid, COUNT(*) AS n FROM story WHERE `+public+` GROUP BY imageid`); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.ImageIdentifier] = row.Count
+	}
+	return counts, nil
+}
+
+// InsertStory saves a new story. PostgreSQL does not support LastInsertId,
+// so the identifier is requested with RETURNING there.
+func (s *SQLStore) InsertStory(story Story) (int64, error) {
+	stmt := `INSERT INTO story (imageid, text, language, ip, flagged) values (?, ?, ?, ?, ?)`
+	args := []interface{}{story.ImageIdentifier, story.Text, story.Language, story.IP, story.Status}
+	if s.db.DriverName() == "postgres" {
+		var id int64
+		err := s.db.Get(&id, s.db.Rebind(stmt+` RETURNING id`), args...)
+		return id, err
+	}
+	result, err := s.db.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// fullTextSchema creates a FTS5 index over story texts, kept up to date by
+// triggers. Diacritics are kept, since they matter in Sorbian.
+const fullTextSchema = `
+CREATE VIRTUAL TABLE story_fts USING fts5(text, content='story', content_rowid='id',
+	tokenize='unicode61 remove_diacritics 0');
+CREATE TRIGGER story_fts_ai AFTER INSERT ON story BEGIN
+	INSERT INTO story_fts(rowid, text) VALUES (new.id, new.text);
+END;
+CREATE TRIGGER story_fts_ad AFTER DELETE ON story BEGIN
+	INSERT INTO story_fts(story_fts, rowid, text) VALUES ('delete', old.id, old.text);
+END;
+CREATE TRIGGER story_fts_au AFTER UPDATE ON story BEGIN
+	INSERT INTO story_fts(story_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	INSERT INTO story_fts(rowid, text) VALUES (new.id, new.text);
+END;
+INSERT INTO story_fts(story_fts) VALUES ('rebuild')`
+
+// requireFTS5 checks, whether sqlite3 has been compiled with FTS5 support
+// (build tag sqlite_fts5).
+func requireFTS5(db *sqlx.DB) error {
+	var enabled bool
+	if err := db.Get(&enabled, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("sqlite3 without FTS5, build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// SetupFullText creates and populates a FTS5 index over story texts, if
+// sqlite3 has been compiled with FTS5 support. It is an optional step, not a
+// numbered migration, so builds without FTS5 still get all migrations and
+// search with LIKE. A database with an index needs FTS5, since every change to
+// a story updates the index.
+func (s *SQLStore) SetupFullText() error {
+	if s.db.DriverName() != "sqlite3" {
+		return nil
+	}
+	var exists int
+	if err := s.db.Get(&exists, `
+	SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'story_fts'`); err != nil {
+		return err
+	}
+	if err := requireFTS5(s.db); err != nil {
+		if exists > 0 {
+			return fmt.Errorf("database has a full text index: %v", err)
+		}
+		log.Printf("search will use LIKE: %v", err)
+		return nil
+	}
+	if exists == 0 {
+		tx, err := s.db.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(fullTextSchema); err != nil {
+			return fmt.Errorf("cannot create full text index: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Println("created full text index")
+	}
+	s.fullText = true
+	return nil
+}
+
+// SearchStories uses the full text index, if available, matching prefixes
+// of words. Otherwise every term must be contained in the text, which finds
+// prefixes as well. LOWER only folds ASCII in sqlite3, so capitalized words
+// with a non-ASCII first letter are matched separately.
+func (s *SQLStore) SearchStories(q SearchQuery) (stories []Story, err error) {
+	terms := q.Terms()
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var (
+		where []string
+		args  []interface{}
+		query string
+	)
+	if s.fullText {
+		query = `
+		SELECT s.id, s.imageid, s.text, s.language, s.flagged, s.created
+		FROM story_fts f JOIN story s ON s.id = f.rowid
+		WHERE story_fts MATCH ? AND s.` + public
+		args = append(args, ftsQuery(terms))
+	} else {
+		for _, t := range terms {
+			cond := `LOWER(s.text) LIKE ? ESCAPE '\'`
+			args = append(args, "%"+escapeLike(t)+"%")
+			if title := titleCase(t); title != t {
+				cond = `(` + cond + ` OR s.text LIKE ? ESCAPE '\')`
+				args = append(args, "%"+escapeLike(title)+"%")
+			}
+			where = append(where, cond)
+		}
+		where = append(where, `s.`+public)
+		query = `
+		SELECT s.id, s.imageid, s.text, s.language, s.flagged, s.created
+		FROM story s WHERE ` + strings.Join(where, " AND ")
+	}
+	if q.Language != "" {
+		query += ` AND s.language = ?`
+		args = append(args, q.Language)
+	}
+	if s.fullText {
+		query += ` ORDER BY f.rank`
+	} else {
+		query += ` ORDER BY s.created DESC, s.id DESC`
+	}
+	query += ` LIMIT ?`
+	args = append(args, q.Limit)
+	err = s.db.Select(&stories, s.db.Rebind(query), args...)
+	return
+}
+
+// ReportStory records a report and flags the story.
+func (s *SQLStore) ReportStory(id int, ip, reason string) error {
+	if _, err := s.StoryByID(id); err != nil {
+		return err
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(tx.Rebind(`INSERT INTO report (storyid, ip, reason) VALUES (?, ?, ?)`),
+		id, ip, reason); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(tx.Rebind(`UPDATE story SET flagged = ? WHERE id = ? AND flagged = ?`),
+		StatusFlagged, id, StatusVisible); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ModerationQueue returns flagged stories, oldest first.
+func (s *SQLStore) ModerationQueue() (items []QueueItem, err error) {
+	var stories []Story
+	if err = s.db.Select(&stories, s.db.Rebind(`
+	SELECT id, imageid, text, language, flagged, created
+	FROM story WHERE flagged = ? ORDER BY created`), StatusFlagged); err != nil {
+		return nil, err
+	}
+	for _, story := range stories {
+		var reports []Report
+		if err = s.db.Select(&reports, s.db.Rebind(`
+		SELECT id, storyid, ip, reason, created
+		FROM report WHERE storyid = ? ORDER BY created`), story.Identifier); err != nil {
+			return nil, err
+		}
+		items = append(items, QueueItem{Story: story, Reports: reports})
+	}
+	return items, nil
+}
+
+// Moderate applies a curator decision.
+func (s *SQLStore) Moderate(id int, action, curator string) error {
+	if err := checkAction(action); err != nil {
+		return err
+	}
+	if _, err := s.StoryByID(id); err != nil {
+		return err
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if action == ActionDelete {
+		if _, err := tx.Exec(tx.Rebind(`DELETE FROM report WHERE storyid = ?`), id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(tx.Rebind(`DELETE FROM story WHERE id = ?`), id); err != nil {
+			return err
+		}
+	} else if label, ok := labelByAction[action]; ok {
+		if _, err := tx.Exec(tx.Rebind(`UPDATE story SET flagged = ?, label = ? WHERE id = ?`),
+			statusByAction[action], label, id); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(tx.Rebind(`UPDATE story SET flagged = ? WHERE id = ?`),
+			statusByAction[action], id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(tx.Rebind(`INSERT INTO moderation_log (storyid, action, curator) VALUES (?, ?, ?)`),
+		id, action, curator); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ModerationLog returns the most recent curator decisions.
+func (s *SQLStore) ModerationLog(limit int) (entries []ModerationEntry, err error) {
+	err = s.db.Select(&entries, s.db.Rebind(`
+	SELECT id, storyid, action, curator, created
+	FROM moderation_log ORDER BY created DESC, id DESC LIMIT ?`), limit)
+	return
+}
+
+// AllStories returns stories regardless of status.
+func (s *SQLStore) AllStories(limit, offset int) (stories []Story, err error) {
+	err = s.db.Select(&stories, s.db.Rebind(`
+	SELECT id, imageid, text, language, flagged, created
+	FROM story ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`), limit, offset)
+	return
+}
+
+// UpdateStory changes text, language and status of a story.
+func (s *SQLStore) UpdateStory(story Story, curator string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(tx.Rebind(`UPDATE story SET text = ?, language = ?, flagged = ? WHERE id = ?`),
+		story.Text, story.Language, story.Status, story.Identifier)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrStoryNotFound
+	}
+	if _, err := tx.Exec(tx.Rebind(`INSERT INTO moderation_log (storyid, action, curator) VALUES (?, ?, ?)`),
+		story.Identifier, ActionEdit, curator); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LabeledStories returns all stories marked as spam or ham.
+func (s *SQLStore) LabeledStories() (stories []Story, err error) {
+	err = s.db.Select(&stories, `
+	SELECT id, imageid, text, language, flagged, label, created
+	FROM story WHERE label != ''`)
+	return
+}
+
+// AddCurator creates or updates a curator, keeping its creation date.
+func (s *SQLStore) AddCurator(name, hash string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(tx.Rebind(`UPDATE curator SET password = ? WHERE name = ?`), hash, name)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := tx.Exec(tx.Rebind(`INSERT INTO curator (name, password) VALUES (?, ?)`), name, hash); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(tx.Rebind(`DELETE FROM session WHERE curator = ?`), name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveCurator deletes a curator and all sessions.
+func (s *SQLStore) RemoveCurator(name string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(tx.Rebind(`DELETE FROM curator WHERE name = ?`), name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrCuratorNotFound
+	}
+	if _, err := tx.Exec(tx.Rebind(`DELETE FROM session WHERE curator = ?`), name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Curators returns all curators.
+func (s *SQLStore) Curators() (curators []Curator, err error) {
+	err = s.db.Select(&curators, `SELECT name, password, created FROM curator ORDER BY name`)
+	return
+}
+
+// CuratorByName returns a single curator.
+func (s *SQLStore) CuratorByName(name string) (*Curator, error) {
+	var c Curator
+	err := s.db.Get(&c, s.db.Rebind(`SELECT name, password, created FROM curator WHERE name = ?`), name)
+	if err == sql.ErrNoRows {
+		return nil, ErrCuratorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreateSession stores a new session and cleans up expired ones.
+func (s *SQLStore) CreateSession(token, curator string, expires time.Time) error {
+	if _, err := s.db.Exec(s.db.Rebind(`DELETE FROM session WHERE expires < ?`), time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(s.db.Rebind(`INSERT INTO session (token, curator, expires) VALUES (?, ?, ?)`),
+		token, curator, expires.UTC())
+	return err
+}
+
+// SessionCurator returns the curator for a valid session.
+func (s *SQLStore) SessionCurator(token string) (string, error) {
+	var session struct {
+		Curator string    `db:"curator"`
+		Expires time.Time `db:"expires"`
+	}
+	err := s.db.Get(&session, s.db.Rebind(`SELECT curator, expires FROM session WHERE token = ?`), token)
+	if err == sql.ErrNoRows {
+		return "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(session.Expires) {
+		return "", ErrSessionNotFound
+	}
+	return session.Curator, nil
+}
+
+// DeleteSession removes a session.
+func (s *SQLStore) DeleteSession(token string) error {
+	_, err := s.db.Exec(s.db.Rebind(`DELETE FROM session WHERE token = ?`), token)
+	return err
+}
+
+// MemoryStore keeps stories in memory, useful for tests and demos.
+type MemoryStore struct {
+	mu       sync.Mutex
+	stories  []Story
+	reports  []Report
+	log      []ModerationEntry
+	curators map[string]Curator
+	sessions map[string]memorySession
+
+	// Last assigned ids, which are never reused, like AUTOINCREMENT.
+	lastStoryID  int
+	lastReportID int
+}
+
+// memorySession is a session kept by MemoryStore.
+type memorySession struct {
+	curator string
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		curators: make(map[string]Curator),
+		sessions: make(map[string]memorySession),
+	}
+}
+
+// newestFirst returns a copy of the stories, which match a filter, sorted by
+// creation date, newest first.
+func (s *MemoryStore) newestFirst(f func(Story) bool) (result []Story) {
+	for _, story := range s.stories {
+		if f(story) {
+			result = append(result, story)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+	return
+}
+
+// StoriesByImage returns all stories for a given image identifier.
+func (s *MemoryStore) StoriesByImage(iid string) ([]Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newestFirst(func(story Story) bool {
+		return story.ImageIdentifier == iid && story.Public()
+	}), nil
+}
+
+// StoryByID returns a single story.
+func (s *MemoryStore) StoryByID(id int) (*Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, story := range s.stories {
+		if story.Identifier == id {
+			return &story, nil
+		}
+	}
+	return nil, ErrStoryNotFound
+}
+
+// RecentStories returns the most recent stories.
+func (s *MemoryStore) RecentStories(limit, offset int) ([]Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stories := s.newestFirst(Story.Public)
+	if offset >= len(stories) {
+		return nil, nil
+	}
+	stories = stories[offset:]
+	if len(stories) > limit {
+		stories = stories[:limit]
+	}
+	return stories, nil
+}
+
+// StoriesByLanguage returns the most recent stories in a language.
+func (s *MemoryStore) StoriesByLanguage(lang string, limit int) ([]Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stories := s.newestFirst(func(story Story) bool {
+		return story.Public() && story.Language == lang
+	})
+	if len(stories) > limit {
+		stories = stories[:limit]
+	}
+	return stories, nil
+}
+
+// StoryCounts returns the number of stories per image.
+func (s *MemoryStore) StoryCounts() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, story := range s.stories {
+		if story.Public() {
+			counts[story.ImageIdentifier]++
+		}
+	}
+	return counts, nil
+}
+
+// InsertStory saves a new story.
+func (s *MemoryStore) InsertStory(story Story) (int64, error) {
+	if !validImageID(story.ImageIdentifier) {
+		return 0, errInvalidImage
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastStoryID++
+	story.Identifier = s.lastStoryID
+	if story.Created.IsZero() {
+		story.Created = time.Now().UTC()
+	}
+	s.stories = append(s.stories, story)
+	return int64(story.Identifier), nil
+}
+
+// SearchStories matches prefixes of words, ignoring case.
+func (s *MemoryStore) SearchStories(q SearchQuery) ([]Story, error) {
+	terms := q.Terms()
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stories := s.newestFirst(func(story Story) bool {
+		if !story.Public() || (q.Language != "" && story.Language != q.Language) {
+			return false
+		}
+		return matchesAll(story.Text, terms)
+	})
+	if len(stories) > q.Limit {
+		stories = stories[:q.Limit]
+	}
+	return stories, nil
+}
+
+// ReportStory records a report and flags the story.
+func (s *MemoryStore) ReportStory(id int, ip, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, story := range s.stories {
+		if story.Identifier != id {
+			continue
+		}
+		s.lastReportID++
+		s.reports = append(s.reports, Report{
+			Identifier:      s.lastReportID,
+			StoryIdentifier: id,
+			IP:              ip,
+			Reason:          reason,
+			Created:         time.Now().UTC(),
+		})
+		if story.Status == StatusVisible {
+			s.stories[i].Status = StatusFlagged
+		}
+		return nil
+	}
+	return ErrStoryNotFound
+}
+
+// ModerationQueue returns flagged stories, oldest first.
+func (s *MemoryStore) ModerationQueue() (items []QueueItem, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stories := s.newestFirst(func(story Story) bool {
+		return story.Status == StatusFlagged
+	})
+	for i := len(stories) - 1; i >= 0; i-- {
+		item := QueueItem{Story: stories[i]}
+		for _, r := range s.reports {
+			if r.StoryIdentifier == stories[i].Identifier {
+				item.Reports = append(item.Reports, r)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Moderate applies a curator decision.
+func (s *MemoryStore) Moderate(id int, action, curator string) error {
+	if err := checkAction(action); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, story := range s.stories {
+		if story.Identifier != id {
+			continue
+		}
+		if action == ActionDelete {
+			s.stories = append(s.stories[:i], s.stories[i+1:]...)
+			var reports []Report
+			for _, r := range s.reports {
+				if r.StoryIdentifier != id {
+					reports = append(reports, r)
+				}
+			}
+			s.reports = reports
+		} else {
+			s.stories[i].Status = statusByAction[action]
+			if label, ok := labelByAction[action]; ok {
+				s.stories[i].Label = label
+			}
+		}
+		s.appendLog(id, action, curator)
+		return nil
+	}
+	return ErrStoryNotFound
+}
+
+// appendLog records a curator decision, callers must hold the lock.
+func (s *MemoryStore) appendLog(id int, action, curator string) {
+	s.log = append(s.log, ModerationEntry{
+		Identifier:      len(s.log) + 1,
+		StoryIdentifier: id,
+		Action:          action,
+		Curator:         curator,
+		Created:         time.Now().UTC(),
+	})
+}
+
+// ModerationLog returns the most recent curator decisions.
+func (s *MemoryStore) ModerationLog(limit int) (entries []ModerationEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.log) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, s.log[i])
+	}
+	return entries, nil
+}
+
+// AllStories returns stories regardless of status.
+func (s *MemoryStore) AllStories(limit, offset int) ([]Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stories := s.newestFirst(func(Story) bool { return true })
+	if offset >= len(stories) {
+		return nil, nil
+	}
+	stories = stories[offset:]
+	if len(stories) > limit {
+		stories = stories[:limit]
+	}
+	return stories, nil
+}
+
+// UpdateStory changes text, language and status of a story.
+func (s *MemoryStore) UpdateStory(story Story, curator string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.stories {
+		if s.stories[i].Identifier != story.Identifier {
+			continue
+		}
+		s.stories[i].Text = story.Text
+		s.stories[i].Language = story.Language
+		s.stories[i].Status = story.Status
+		s.appendLog(story.Identifier, ActionEdit, curator)
+		return nil
+	}
+	return ErrStoryNotFound
+}
+
+// LabeledStories returns all stories marked as spam or ham.
+func (s *MemoryStore) LabeledStories() ([]Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newestFirst(func(story Story) bool {
+		return story.Label != ""
+	}), nil
+}
+
+// AddCurator creates or updates a curator, keeping its creation date.
+func (s *MemoryStore) AddCurator(name, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.curators[name]
+	if !ok {
+		c = Curator{Name: name, Created: time.Now().UTC()}
+	}
+	c.Password = hash
+	s.curators[name] = c
+	for token, session := range s.sessions {
+		if session.curator == name {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+// RemoveCurator deletes a curator and all sessions.
+func (s *MemoryStore) RemoveCurator(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.curators[name]; !ok {
+		return ErrCuratorNotFound
+	}
+	delete(s.curators, name)
+	for token, session := range s.sessions {
+		if session.curator == name {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+// Curators returns all curators.
+func (s *MemoryStore) Curators() (curators []Curator, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.curators {
+		curators = append(curators, c)
+	}
+	sort.Slice(curators, func(i, j int) bool {
+		return curators[i].Name < curators[j].Name
+	})
+	return curators, nil
+}
+
+// CuratorByName returns a single curator.
+func (s *MemoryStore) CuratorByName(name string) (*Curator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.curators[name]
+	if !ok {
+		return nil, ErrCuratorNotFound
+	}
+	return &c, nil
+}
+
+// CreateSession stores a new session.
+func (s *MemoryStore) CreateSession(token, curator string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[token] = memorySession{curator: curator, expires: expires}
+	return nil
+}
+
+// SessionCurator returns the curator for a valid session.
+func (s *MemoryStore) SessionCurator(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[token]
+	if !ok || time.Now().After(session.expires) {
+		return "", ErrSessionNotFound
+	}
+	return session.curator, nil
+}
+
+// DeleteSession removes a session.
+func (s *MemoryStore) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
+
+// countsMaxAge limits how long cached story counts are used, so changes by
+// other processes sharing the database show up eventually.
+const countsMaxAge = time.Minute
+
+// countingStore caches story counts, which some strategies need for every
+// random combination. Changes to stories through the store drop the cache.
+type countingStore struct {
+	Store
+
+	mu      sync.Mutex
+	counts  map[string]int
+	updated time.Time
+}
+
+// StoryCounts returns a copy of the cached counts, which are loaded, if
+// missing or too old.
+func (s *countingStore) StoryCounts() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil || time.Since(s.updated) > countsMaxAge {
+		counts, err := s.Store.StoryCounts()
+		if err != nil {
+			return nil, err
+		}
+		s.counts, s.updated = counts, time.Now()
+	}
+	counts := make(map[string]int, len(s.counts))
+	for k, v := range s.counts {
+		counts[k] = v
+	}
+	return counts, nil
+}
+
+// invalidate drops the cached counts.
+func (s *countingStore) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = nil
+}
+
+// InsertStory saves a new story.
+func (s *countingStore) InsertStory(story Story) (int64, error) {
+	defer s.invalidate()
+	return s.Store.InsertStory(story)
+}
+
+// ReportStory records a report, which may hide the story.
+func (s *countingStore) ReportStory(id int, ip, reason string) error {
+	defer s.invalidate()
+	return s.Store.ReportStory(id, ip, reason)
+}
+
+// Moderate applies a curator decision.
+func (s *countingStore) Moderate(id int, action, curator string) error {
+	defer s.invalidate()
+	return s.Store.Moderate(id, action, curator)
+}
+
+// UpdateStory changes a story, including its status.
+func (s *countingStore) UpdateStory(story Story, curator string) error {
+	defer s.invalidate()
+	return s.Store.UpdateStory(story, curator)
+}
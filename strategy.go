@@ -0,0 +1,197 @@
+package dvmweb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Strategy decides, how random combinations are drawn.
+type Strategy string
+
+const (
+	// Uniform draws every combination with the same probability.
+	Uniform Strategy = "uniform"
+	// PreferUnwritten favors combinations with few or no stories, weight
+	// 1/(1+n) for n stories.
+	PreferUnwritten Strategy = "prefer-unwritten"
+	// PreferPopular draws from combinations with stories, weighted by the
+	// number of stories. Uniform, if there are no stories yet.
+	PreferPopular Strategy = "prefer-popular"
+	// LeastRecentlyShown draws a few candidates and takes the one, which has
+	// not been shown for the longest time.
+	LeastRecentlyShown Strategy = "least-recently-shown"
+)
+
+// Strategies lists all known strategies.
+var Strategies = []Strategy{Uniform, PreferUnwritten, PreferPopular, LeastRecentlyShown}
+
+// StrategyRoutes lists the routes, which draw random combinations.
+var StrategyRoutes = []string{"rand", "index", "api"}
+
+// Tuning of the sampling strategies.
+const (
+	maxRejections = 100 // Give up weighting after this many draws.
+	lrsCandidates = 8   // Candidates compared by LeastRecentlyShown.
+)
+
+// ParseStrategy returns the strategy for a name.
+func ParseStrategy(s string) (Strategy, error) {
+	for _, v := range Strategies {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	var names []string
+	for _, v := range Strategies {
+		names = append(names, string(v))
+	}
+	return "", fmt.Errorf("unknown strategy %q, want one of: %s", s, strings.Join(names, ", "))
+}
+
+// NeedsCounts returns true, if the strategy consults story counts.
+func (s Strategy) NeedsCounts() bool {
+	return s == PreferUnwritten || s == PreferPopular
+}
+
+// RandomImageIdentifierWith draws a combination with a given strategy. The
+// counts map image identifiers to their number of public stories, it may be
+// nil for strategies not consulting it.
+func (inv *Inventory) RandomImageIdentifierWith(s Strategy, counts map[string]int) (string, error) {
+	var (
+		iid string
+		err error
+	)
+	switch s {
+	case Uniform, "":
+		iid, err = inv.RandomImageIdentifier()
+	case PreferUnwritten:
+		iid, err = inv.preferUnwritten(counts)
+	case PreferPopular:
+		iid, err = inv.preferPopular(counts)
+	case LeastRecentlyShown:
+		iid, err = inv.leastRecentlyShown()
+	default:
+		return "", fmt.Errorf("unknown strategy: %s", s)
+	}
+	if err != nil {
+		return "", err
+	}
+	inv.MarkShown(iid)
+	return iid, nil
+}
+
+// preferUnwritten uses rejection sampling: a uniformly drawn combination with
+// n stories is accepted with probability 1/(1+n).
+func (inv *Inventory) preferUnwritten(counts map[string]int) (iid string, err error) {
+	for i := 0; i < maxRejections; i++ {
+		if iid, err = inv.RandomImageIdentifier(); err != nil {
+			return "", err
+		}
+		if inv.intn(1+counts[iid]) == 0 {
+			return iid, nil
+		}
+	}
+	return iid, nil
+}
+
+// preferPopular draws a story uniformly, which selects combinations
+// proportional to their number of stories. Combinations no longer in the
+// inventory are skipped.
+func (inv *Inventory) preferPopular(counts map[string]int) (string, error) {
+	var (
+		iids  []string
+		total int
+	)
+	for iid, n := range counts {
+		if _, err := inv.ParseCompositeID(iid); err != nil || n <= 0 {
+			continue
+		}
+		iids = append(iids, iid)
+		total += n
+	}
+	if total == 0 {
+		return inv.RandomImageIdentifier()
+	}
+	// Map iteration order is random, sort for reproducible draws.
+	sort.Strings(iids)
+	k := inv.intn(total)
+	for _, iid := range iids {
+		if k -= counts[iid]; k < 0 {
+			return iid, nil
+		}
+	}
+	return iids[len(iids)-1], nil
+}
+
+// leastRecentlyShown compares a few uniformly drawn candidates.
+func (inv *Inventory) leastRecentlyShown() (best string, err error) {
+	var bestShown time.Time
+	for i := 0; i < lrsCandidates; i++ {
+		iid, err := inv.RandomImageIdentifier()
+		if err != nil {
+			return "", err
+		}
+		shown := inv.lastShown(iid)
+		if best == "" || shown.Before(bestShown) {
+			best, bestShown = iid, shown
+		}
+	}
+	return best, nil
+}
+
+// MarkShown records, that a combination has been shown to a visitor.
+func (inv *Inventory) MarkShown(iid string) {
+	sel := inv.selection()
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	sel.shown[iid] = time.Now()
+}
+
+// lastShown returns the time a combination has been shown last, zero if never.
+func (inv *Inventory) lastShown(iid string) time.Time {
+	sel := inv.selection()
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	return sel.shown[iid]
+}
+
+// ParseRouteStrategies parses a comma separated list of route=strategy pairs,
+// e.g. "rand=prefer-unwritten,api=uniform". Routes are named in
+// StrategyRoutes.
+func ParseRouteStrategies(s string) (map[string]Strategy, error) {
+	m := make(map[string]Strategy)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid route strategy %q, want route=strategy", v)
+		}
+		if !isStrategyRoute(parts[0]) {
+			return nil, fmt.Errorf("unknown route %q, want one of: %s", parts[0], strings.Join(StrategyRoutes, ", "))
+		}
+		if _, ok := m[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate route %q", parts[0])
+		}
+		strategy, err := ParseStrategy(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		m[parts[0]] = strategy
+	}
+	return m, nil
+}
+
+// isStrategyRoute returns true, if the route draws random combinations.
+func isStrategyRoute(route string) bool {
+	for _, v := range StrategyRoutes {
+		if v == route {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package dvmweb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRouteStrategies(t *testing.T) {
+	var cases = []struct {
+		s    string
+		want map[string]Strategy
+		err  bool
+	}{
+		{"", map[string]Strategy{}, false},
+		{"rand=uniform,index=uniform,api=uniform", map[string]Strategy{"rand": Uniform, "index": Uniform, "api": Uniform}, false},
+		{" rand=prefer-unwritten , api=least-recently-shown,", map[string]Strategy{"rand": PreferUnwritten, "api": LeastRecentlyShown}, false},
+		{"rand", nil, true},
+		{"rand=bogus", nil, true},
+		{"random=uniform", nil, true},
+		{"search=uniform", nil, true},
+		{"=uniform", nil, true},
+		{"rand=uniform,rand=prefer-popular", nil, true},
+	}
+	for _, c := range cases {
+		got, err := ParseRouteStrategies(c.s)
+		if (err != nil) != c.err {
+			t.Errorf("%q: got error %v, want error %v", c.s, err, c.err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, want %v", c.s, got, c.want)
+		}
+	}
+}